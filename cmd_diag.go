@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nolka/gooffroadmaster/mvc"
+	"github.com/nolka/gooffroadmaster/netdiag"
+	"gopkg.in/telegram-bot-api.v4"
+)
+
+const (
+	diagProbes         = 4
+	diagDefaultPort    = 443
+	diagResolveTimeout = 5 * time.Second
+	diagProbeTimeout   = 2 * time.Second
+)
+
+// diagCommand checks that a host resolves and answers. It runs probes from the
+// bot host, so it is available to admins only and private ranges are denied.
+func diagCommand(manager *mvc.Router, policy *netdiag.Policy) *mvc.Command {
+	return &mvc.Command{
+		Name:        "diag",
+		Description: "Проверить доступность хоста",
+		Args: []mvc.CommandArg{
+			{Name: "host", Description: "имя или адрес хоста", Required: true},
+			{Name: "port", Description: fmt.Sprintf("TCP порт, по умолчанию %d", diagDefaultPort)},
+		},
+		AdminOnly: true,
+		Handler: func(message *tgbotapi.Message, args mvc.CommandArgs) {
+			msg := tgbotapi.NewMessage(message.Chat.ID, "")
+			msg.ReplyToMessageID = message.MessageID
+			msg.Text = diagnose(args["host"], args["port"], policy)
+			manager.Send(msg)
+		},
+	}
+}
+
+func diagnose(host, portArg string, policy *netdiag.Policy) string {
+	port := diagDefaultPort
+	if portArg != "" {
+		p, err := strconv.Atoi(portArg)
+		if err != nil || p < 1 || p > 65535 {
+			return "Неверный номер порта"
+		}
+		port = p
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), diagResolveTimeout)
+	defer cancel()
+	ips, elapsed, err := netdiag.Resolve(ctx, host, policy)
+	if err != nil {
+		return fmt.Sprintf("DNS %s: %s", host, err)
+	}
+
+	b := &strings.Builder{}
+	var addrs []string
+	for _, ip := range ips {
+		addrs = append(addrs, ip.String())
+	}
+	fmt.Fprintf(b, "DNS %s: %s (%s)\n", host, strings.Join(addrs, ", "), elapsed.Round(time.Millisecond))
+
+	ip := ips[0]
+	stats, err := netdiag.PingICMP(ip, diagProbes, diagProbeTimeout)
+	switch {
+	case err == nil:
+		b.WriteString(stats.String())
+	case err != netdiag.ErrICMPNotPermitted:
+		fmt.Fprintf(b, "ICMP %s: %s\n", ip, err)
+	}
+	if err != nil || portArg != "" {
+		b.WriteString(netdiag.PingTCP(ip, port, diagProbes, diagProbeTimeout).String())
+	}
+	return b.String()
+}
@@ -1,30 +1,18 @@
 package main
 
 import (
-	"errors"
-	"gopkg.in/telegram-bot-api.v4"
-)
-
-type Command struct {
-	Command string
-	Args    []string
-}
-
-type ExecutableCommand interface {
-	Handle(message *tgbotapi.Message, bot *tgbotapi.BotAPI) (tgbotapi.MessageConfig, error)
-	SetArgs(args []string)
-}
+	"log"
 
-func (c *Command) SetArgs(args []string) {
-	c.Args = args
-}
-
-func (c *Command) Handle(message *tgbotapi.Message, bot *tgbotapi.BotAPI) (tgbotapi.MessageConfig, error) {
-	return tgbotapi.MessageConfig{}, errors.New("Not implemented!")
-}
+	"github.com/nolka/gooffroadmaster/mvc"
+	"github.com/nolka/gooffroadmaster/netdiag"
+)
 
-func EnumerateCommands() map[string]ExecutableCommand {
-	return map[string]ExecutableCommand{
-		"ping": &Ping{},
+// registerCommands adds commands which don't belong to any controller.
+func registerCommands(manager *mvc.Router, config *Config) {
+	policy, err := netdiag.NewPolicy(config.DiagAllow, config.DiagDeny)
+	if err != nil {
+		log.Printf("Bad diagnostics allow/deny lists: %s, using defaults\n", err)
+		policy, _ = netdiag.NewPolicy(nil, nil)
 	}
+	manager.RegisterCommand(diagCommand(manager, policy))
 }
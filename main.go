@@ -4,14 +4,22 @@ import (
 	"encoding/json"
 	"github.com/nolka/gooffroadmaster/mvc"
 	"github.com/nolka/gooffroadmaster/mvc/controllers"
+	"github.com/nolka/gooffroadmaster/sender"
 	"github.com/nolka/gooffroadmaster/util"
 	"gopkg.in/telegram-bot-api.v4"
 	"io/ioutil"
 	"log"
 	"os"
 	"os/signal"
+	"time"
 )
 
+// Updates from one user above this limit are dropped
+const updatesPerMinute = 60
+
+// Buttons with long callback data stop working after this time
+const callbackPayloadTTL = 30 * 24 * time.Hour
+
 func main() {
 	util.EnsureDirectories()
 
@@ -30,27 +38,27 @@ func main() {
 	u.Timeout = 60
 
 	updates, err := bot.GetUpdatesChan(u)
-	var results = make(chan tgbotapi.MessageConfig)
+	var results = make(chan mvc.Result)
 	manager := mvc.NewMessageRouter(bot, results)
 	manager.RegisterController(controllers.NewTrackConverter(manager, util.GetRuntimePath()))
 	manager.RegisterController(controllers.NewInteractiveMenu(manager))
+	manager.Admins = config.Admins
+	manager.Payloads = mvc.NewPayloadStore(util.MakePath(util.GetRuntimePath(), "callback_payloads.json"), callbackPayloadTTL)
+	manager.Use(
+		mvc.Recover(manager),
+		mvc.Logging(),
+		mvc.RateLimit(manager, updatesPerMinute, time.Minute),
+	)
+	registerCommands(manager, config)
+	if err = manager.PublishCommands(); err != nil {
+		log.Printf("Failed to publish commands: %s\n", err)
+	}
 
 	subscribeInterrupt(manager)
 
-	go resultsSender(results, bot)
+	go sender.NewSender(bot).Run(results)
 	for update := range updates {
-		if update.Message != nil {
-			log.Printf("[%s] => %s\n", update.Message.From.UserName, update.Message.Text)
-		}
-		go func() {
-			manager.Dispatch(update)
-		}()
-	}
-}
-
-func resultsSender(message chan tgbotapi.MessageConfig, bot *tgbotapi.BotAPI) {
-	for message := range message {
-		bot.Send(message)
+		manager.Enqueue(update)
 	}
 }
 
@@ -87,64 +95,3 @@ func getConfig() *Config {
 	cfg.RuntimeDir = cfg.WorkDir + string(os.PathSeparator) + "runtime"
 	return cfg
 }
-
-//TODO: Move to another controller
-
-
-// func instantiate(p reflect.Type) ExecutableCommand {
-// 	instance := reflect.New(p).Elem()
-// 	return  instance.Interface().(ExecutableCommand)
-// }
-
-// func getCommand(cmd string, args []string) ExecutableCommand {
-// 	for c, instance := range EnumerateCommands() {
-// 		if cmd == c {
-// 			// instance := instantiate(typeName).(ExecutableCommand)
-// 			instance.SetArgs(args)
-// 			return instance
-// 		}
-// 	}
-// 	return nil
-// }
-
-// func parseCommand(command string) ExecutableCommand {
-// 	if !strings.HasPrefix(command, "/") {
-// 		return nil
-// 	}
-// 	parts := strings.Split(command[1:], " ")
-// 	cmd := parts[0]
-// 	parts = parts[1:]
-//
-// 	instance := getCommand(cmd, parts)
-// 	if instance == nil {
-// 		log.Printf("Failed to find command handler for '%s'", cmd)
-// 		return nil
-// 	}
-// 	return instance
-// }
-
-// func handleChannelMessage(update *tgbotapi.Update, bot *tgbotapi.BotAPI, results chan tgbotapi.MessageConfig) {
-// 	message := update.Message
-//
-// 	go resultsSender(results, bot)
-// 	go func() {
-// 		if strings.HasPrefix(message.Text, "/") {
-// 			cmd := parseCommand(message.Text)
-// 			if cmd == nil {
-// 				return
-// 			}
-// 			result, err := cmd.Handle(message, bot)
-// 			if err != nil {
-// 				log.Printf("ERROR: %s", err)
-// 				return
-// 			}
-// 			results <- result
-// 			return
-// 		}
-// 	}()
-// }
-
-// func handlePrivateMessage(update *tgbotapi.Update, bot *tgbotapi.BotAPI, results chan tgbotapi.MessageConfig) {
-// 	message := update.Message
-// 	log.Println(message.Chat.Title)
-// }
@@ -0,0 +1,71 @@
+package mvc
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"gopkg.in/telegram-bot-api.v4"
+)
+
+const expiredButtonText = "Эта кнопка устарела, отправьте запрос заново"
+
+// Versioned is implemented by controllers which changed their callback data
+// layout. Buttons made with another version are answered as expired.
+// Controllers without it have version 1.
+type Versioned interface {
+	CallbackVersion() int
+}
+
+func versionOf(c BotMessageComponentInterface) int {
+	if v, ok := c.(Versioned); ok {
+		return v.CallbackVersion()
+	}
+	return 1
+}
+
+// CallbackData builds callback data "<namespace>:<version>|<part>|<part>...".
+func CallbackData(namespace string, version int, parts ...string) string {
+	return fmt.Sprintf("%s:%d|%s", namespace, version, strings.Join(parts, "|"))
+}
+
+// ParseCallbackPrefix returns the namespace and version of callback data.
+func ParseCallbackPrefix(data string) (string, int, bool) {
+	prefix := strings.SplitN(data, "|", 2)[0]
+	sep := strings.LastIndex(prefix, ":")
+	if sep <= 0 {
+		return "", 0, false
+	}
+	version, err := strconv.Atoi(prefix[sep+1:])
+	if err != nil {
+		return "", 0, false
+	}
+	return prefix[:sep], version, true
+}
+
+// controllerForCallback finds the owner of the button, nil if the button is
+// unknown or was made by another version of the controller.
+func (m *Router) controllerForCallback(data string) BotMessageComponentInterface {
+	namespace, version, ok := ParseCallbackPrefix(data)
+	if !ok {
+		return nil
+	}
+	c, ok := m.Controllers[namespace]
+	if !ok || versionOf(c) != version {
+		return nil
+	}
+	return c
+}
+
+func (m *Router) answerExpired(callback *tgbotapi.CallbackQuery) {
+	answer := tgbotapi.NewCallbackWithAlert(callback.ID, expiredButtonText)
+	m.AnswerCallback(answer)
+}
+
+// answerDropped answers the callback of an update nobody handles, so the
+// client stops showing progress on the button.
+func (m *Router) answerDropped(update tgbotapi.Update, text string) {
+	if update.CallbackQuery != nil {
+		m.AnswerCallback(tgbotapi.NewCallback(update.CallbackQuery.ID, text))
+	}
+}
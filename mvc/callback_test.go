@@ -0,0 +1,33 @@
+package mvc
+
+import "testing"
+
+func TestParseCallbackPrefix(t *testing.T) {
+	tests := []struct {
+		data          string
+		wantNamespace string
+		wantVersion   int
+		wantOk        bool
+	}{
+		{"conv:1|convert|.gpx", "conv", 1, true},
+		{"menu:12|form|0|skip", "menu", 12, true},
+		{"conv:0", "conv", 0, true},
+		{"a:b:2|x", "a:b", 2, true},
+		{CallbackData("conv", 3, "merge", "start"), "conv", 3, true},
+		{"conv|convert", "", 0, false},
+		{"conv:|convert", "", 0, false},
+		{"conv:x|convert", "", 0, false},
+		{":1|convert", "", 0, false},
+		{"convert|conv:1", "", 0, false},
+		{"~token", "", 0, false},
+		{"", "", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.data, func(t *testing.T) {
+			namespace, version, ok := ParseCallbackPrefix(tt.data)
+			if ok != tt.wantOk || namespace != tt.wantNamespace || version != tt.wantVersion {
+				t.Errorf("Parsed as %q %d %v, want %q %d %v", namespace, version, ok, tt.wantNamespace, tt.wantVersion, tt.wantOk)
+			}
+		})
+	}
+}
@@ -0,0 +1,217 @@
+package mvc
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+
+	"gopkg.in/telegram-bot-api.v4"
+)
+
+// ChatScope limits chat types where a command is available.
+type ChatScope int
+
+const (
+	ScopeAll ChatScope = iota
+	ScopePrivate
+	ScopeGroup
+)
+
+const (
+	helpCommand          = "help"
+	commandForbiddenText = "Эта команда здесь недоступна"
+)
+
+// CommandArg describes a command argument. Arguments are separated by spaces,
+// Rest argument takes the rest of the line and may only be the last one.
+type CommandArg struct {
+	Name        string
+	Description string
+	Required    bool
+	Rest        bool
+}
+
+// CommandArgs maps argument names to given values.
+type CommandArgs map[string]string
+
+type CommandHandler func(message *tgbotapi.Message, args CommandArgs)
+
+type Command struct {
+	// Name without the leading slash
+	Name        string
+	Description string
+	Args        []CommandArg
+	Scope       ChatScope
+	// Only users from Router.Admins may run the command
+	AdminOnly bool
+	Handler   CommandHandler
+}
+
+// Usage returns the command with its arguments, e.g. "/ping <host>".
+func (c *Command) Usage() string {
+	usage := "/" + c.Name
+	for _, arg := range c.Args {
+		name := arg.Name
+		if arg.Rest {
+			name += "..."
+		}
+		if arg.Required {
+			usage += " <" + name + ">"
+		} else {
+			usage += " [" + name + "]"
+		}
+	}
+	return usage
+}
+
+// Allowed checks the command scope for the chat.
+func (c *Command) Allowed(chat *tgbotapi.Chat) bool {
+	switch c.Scope {
+	case ScopePrivate:
+		return chat.IsPrivate()
+	case ScopeGroup:
+		return chat.IsGroup() || chat.IsSuperGroup()
+	}
+	return true
+}
+
+// ParseArgs matches arguments to the command schema.
+func (c *Command) ParseArgs(args []string) (CommandArgs, error) {
+	parsed := CommandArgs{}
+	for i, arg := range c.Args {
+		if i >= len(args) {
+			if arg.Required {
+				return nil, fmt.Errorf("Не указан аргумент %s", arg.Name)
+			}
+			continue
+		}
+		if arg.Rest {
+			parsed[arg.Name] = strings.Join(args[i:], " ")
+			return parsed, nil
+		}
+		parsed[arg.Name] = args[i]
+	}
+	if len(args) > len(c.Args) {
+		return nil, fmt.Errorf("Лишние аргументы: %s", strings.Join(args[len(c.Args):], " "))
+	}
+	return parsed, nil
+}
+
+// ParseCommand splits "/cmd@botname args" into the command name and arguments.
+// It returns false if the text is not a command or is addressed to another bot.
+func ParseCommand(text, botName string) (string, []string, bool) {
+	fields := strings.Fields(text)
+	if len(fields) == 0 || !strings.HasPrefix(fields[0], "/") {
+		return "", nil, false
+	}
+	name := strings.TrimPrefix(fields[0], "/")
+	if at := strings.Index(name, "@"); at >= 0 {
+		if !strings.EqualFold(name[at+1:], botName) {
+			return "", nil, false
+		}
+		name = name[:at]
+	}
+	return strings.ToLower(name), fields[1:], name != ""
+}
+
+// available checks the command scope and permissions for the message sender.
+func (m *Router) available(command *Command, message *tgbotapi.Message) bool {
+	if command.AdminOnly && (message.From == nil || !m.IsAdmin(message.From.ID)) {
+		return false
+	}
+	return command.Allowed(message.Chat)
+}
+
+// RegisterCommand makes the command available, replacing one with the same name.
+func (m *Router) RegisterCommand(command *Command) {
+	m.Commands[command.Name] = command
+}
+
+func (m *Router) sortedCommands() []*Command {
+	var commands []*Command
+	for _, c := range m.Commands {
+		commands = append(commands, c)
+	}
+	sort.Slice(commands, func(i, j int) bool {
+		return commands[i].Name < commands[j].Name
+	})
+	return commands
+}
+
+// dispatchCommand runs the registered command and returns false if the message is not one.
+// Commands the sender may not run here are not passed to controllers either.
+func (m *Router) dispatchCommand(message *tgbotapi.Message) bool {
+	name, args, ok := ParseCommand(message.Text, m.Bot.Self.UserName)
+	if !ok {
+		return false
+	}
+	command, ok := m.Commands[name]
+	if !ok {
+		return false
+	}
+	if !m.available(command, message) {
+		m.reply(message, commandForbiddenText)
+		return true
+	}
+
+	parsed, err := command.ParseArgs(args)
+	if err != nil {
+		m.reply(message, fmt.Sprintf("%s\nИспользование: %s", err, command.Usage()))
+		return true
+	}
+	command.Handler(message, parsed)
+	return true
+}
+
+func (m *Router) reply(message *tgbotapi.Message, text string) {
+	msg := tgbotapi.NewMessage(message.Chat.ID, text)
+	msg.ReplyToMessageID = message.MessageID
+	m.Send(msg)
+}
+
+func (m *Router) helpCommand() *Command {
+	return &Command{
+		Name:        helpCommand,
+		Description: "Список команд",
+		Handler: func(message *tgbotapi.Message, args CommandArgs) {
+			b := &strings.Builder{}
+			for _, c := range m.sortedCommands() {
+				if !m.available(c, message) {
+					continue
+				}
+				fmt.Fprintf(b, "%s — %s\n", c.Usage(), c.Description)
+				for _, arg := range c.Args {
+					if arg.Description != "" {
+						fmt.Fprintf(b, "    %s: %s\n", arg.Name, arg.Description)
+					}
+				}
+			}
+			m.reply(message, b.String())
+		},
+	}
+}
+
+type botCommand struct {
+	Command     string `json:"command"`
+	Description string `json:"description"`
+}
+
+// PublishCommands sends the command list to Telegram, so clients show it in the menu.
+// Admin commands are not published.
+func (m *Router) PublishCommands() error {
+	var commands []botCommand
+	for _, c := range m.sortedCommands() {
+		if c.AdminOnly {
+			continue
+		}
+		commands = append(commands, botCommand{Command: c.Name, Description: c.Description})
+	}
+	b, err := json.Marshal(commands)
+	if err != nil {
+		return err
+	}
+	_, err = m.Bot.MakeRequest("setMyCommands", url.Values{"commands": {string(b)}})
+	return err
+}
@@ -0,0 +1,84 @@
+package mvc
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseCommand(t *testing.T) {
+	tests := []struct {
+		text     string
+		wantName string
+		wantArgs []string
+		wantOk   bool
+	}{
+		{"/help", "help", []string{}, true},
+		{"/Diag example.com 443", "diag", []string{"example.com", "443"}, true},
+		{"  /diag   example.com  ", "diag", []string{"example.com"}, true},
+		{"/diag@OffroadBot example.com", "diag", []string{"example.com"}, true},
+		{"/diag@offroadbot", "diag", []string{}, true},
+		{"/diag@OtherBot example.com", "", nil, false},
+		{"/", "", []string{}, false},
+		{"hello /diag", "", nil, false},
+		{"", "", nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			name, args, ok := ParseCommand(tt.text, "OffroadBot")
+			if ok != tt.wantOk {
+				t.Fatalf("Parsed as %v, want %v", ok, tt.wantOk)
+			}
+			if !ok {
+				return
+			}
+			if name != tt.wantName || !reflect.DeepEqual(args, tt.wantArgs) {
+				t.Errorf("Parsed as %q %q, want %q %q", name, args, tt.wantName, tt.wantArgs)
+			}
+		})
+	}
+}
+
+func TestParseArgs(t *testing.T) {
+	command := &Command{
+		Name: "diag",
+		Args: []CommandArg{
+			{Name: "host", Required: true},
+			{Name: "port"},
+		},
+	}
+	rest := &Command{
+		Name: "say",
+		Args: []CommandArg{
+			{Name: "chat", Required: true},
+			{Name: "text", Rest: true},
+		},
+	}
+
+	tests := []struct {
+		name    string
+		command *Command
+		args    []string
+		want    CommandArgs
+		wantErr bool
+	}{
+		{"required and optional", command, []string{"example.com", "443"}, CommandArgs{"host": "example.com", "port": "443"}, false},
+		{"optional missing", command, []string{"example.com"}, CommandArgs{"host": "example.com"}, false},
+		{"required missing", command, nil, nil, true},
+		{"extra", command, []string{"example.com", "443", "tcp"}, nil, true},
+		{"rest", rest, []string{"42", "hello", "there"}, CommandArgs{"chat": "42", "text": "hello there"}, false},
+		{"rest missing", rest, []string{"42"}, CommandArgs{"chat": "42"}, false},
+		{"no args", &Command{Name: "help"}, nil, CommandArgs{}, false},
+		{"unexpected args", &Command{Name: "help"}, []string{"me"}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.command.ParseArgs(tt.args)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Error is %v, want error %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Parsed as %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
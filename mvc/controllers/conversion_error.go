@@ -0,0 +1,102 @@
+package controllers
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/nolka/gooffroadmaster/track"
+)
+
+type ConversionErrorKind int
+
+const (
+	ErrConversionFailed ConversionErrorKind = iota
+	ErrDownloadFailed
+	ErrUnsupportedFormat
+	ErrParseFailed
+	ErrConverterCrashed
+	ErrOutputEmpty
+	ErrUploadFailed
+)
+
+const stderrExcerptLength = 300
+
+// ConversionError describes a failure in the conversion pipeline and knows
+// how to explain it to the user.
+type ConversionError struct {
+	Kind     ConversionErrorKind
+	FileName string
+	// Line of the source file where parsing failed, zero if unknown
+	Line int
+	// Tail of the external converter output, it is logged but not shown to the user
+	Stderr string
+	Err    error
+}
+
+func NewConversionError(kind ConversionErrorKind, fileName string, err error) *ConversionError {
+	return &ConversionError{Kind: kind, FileName: fileName, Err: err}
+}
+
+// AsConversionError wraps any pipeline error into ConversionError, recognizing track package errors.
+func AsConversionError(err error, fileName string) *ConversionError {
+	if err == track.ErrEmptyTrack {
+		return NewConversionError(ErrOutputEmpty, fileName, err)
+	}
+	switch e := err.(type) {
+	case *ConversionError:
+		if e.FileName == "" {
+			e.FileName = fileName
+		}
+		return e
+	case *track.ParseError:
+		c := NewConversionError(ErrParseFailed, fileName, err)
+		c.Line = e.Line
+		return c
+	case *track.UnsupportedFormatError:
+		return NewConversionError(ErrUnsupportedFormat, fileName, err)
+	}
+	return NewConversionError(ErrConversionFailed, fileName, err)
+}
+
+func (e *ConversionError) Error() string {
+	msg := fmt.Sprintf("conversion of %s failed (kind %d)", e.FileName, e.Kind)
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	if e.Stderr != "" {
+		msg += "\n" + e.Stderr
+	}
+	return msg
+}
+
+// UserMessage returns the explanation shown in the chat.
+func (e *ConversionError) UserMessage() string {
+	switch e.Kind {
+	case ErrDownloadFailed:
+		return fmt.Sprintf("Не удалось скачать файл %s из Telegram, попробуйте ещё раз позже", e.FileName)
+	case ErrUnsupportedFormat:
+		return fmt.Sprintf("Формат файла %s не поддерживается", e.FileName)
+	case ErrParseFailed:
+		if e.Line > 0 {
+			return fmt.Sprintf("Не удалось разобрать файл %s: ошибка в строке %d", e.FileName, e.Line)
+		}
+		return fmt.Sprintf("Не удалось разобрать файл %s, похоже, он повреждён", e.FileName)
+	case ErrConverterCrashed:
+		// Stderr is only logged, it exposes paths on the server
+		return fmt.Sprintf("Конвертер завершился с ошибкой при обработке файла %s", e.FileName)
+	case ErrOutputEmpty:
+		return fmt.Sprintf("После конвертации файла %s получился пустой файл, возможно, в нём нет треков", e.FileName)
+	case ErrUploadFailed:
+		return "Не удалось отправить результат, попробуйте ещё раз позже"
+	}
+	return fmt.Sprintf("Не удалось сконвертировать файл %s", e.FileName)
+}
+
+// stderrExcerpt keeps the last lines of converter output, which usually hold the error.
+func stderrExcerpt(stderr string) string {
+	stderr = strings.TrimSpace(stderr)
+	if r := []rune(stderr); len(r) > stderrExcerptLength {
+		stderr = "..." + string(r[len(r)-stderrExcerptLength:])
+	}
+	return stderr
+}
@@ -0,0 +1,261 @@
+package controllers
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	JobStatusQueued      = "queued"
+	JobStatusDownloading = "downloading"
+	JobStatusConverting  = "converting"
+	JobStatusUploading   = "uploading"
+)
+
+type ConversionJob struct {
+	Id               int64     `json:"id"`
+	ChatId           int64     `json:"chat_id"`
+	ReplyToMessageId int       `json:"reply_to_message_id"`
+	StatusMessageId  int       `json:"status_message_id"`
+	FileId           string    `json:"file_id"`
+	FileName         string    `json:"file_name"`
+	DestFormat       string    `json:"dest_format"`
+	Simplify         string    `json:"simplify"`
+	Status           string    `json:"status"`
+	CreatedAt        time.Time `json:"created_at"`
+	// Work other than conversion, like statistics or split, with its arguments
+	Action string   `json:"action,omitempty"`
+	Args   []string `json:"args,omitempty"`
+	// Documents of a merge job
+	Files []mergeFile `json:"files,omitempty"`
+}
+
+type jobHandler func(job *ConversionJob)
+
+// ConversionQueue is a bounded worker pool for conversion jobs. Pending jobs
+// are grouped by chat and taken round-robin, so one chat sending many files
+// can't starve the others. The queue is saved to disk on every change.
+type ConversionQueue struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	path    string
+	workers int
+	handler jobHandler
+	lastId  int64
+	chats   []int64
+	pending map[int64][]*ConversionJob
+	active  map[int64]*ConversionJob
+}
+
+type conversionQueueState struct {
+	LastId  int64                      `json:"last_id"`
+	Chats   []int64                    `json:"chats"`
+	Pending map[int64][]*ConversionJob `json:"pending"`
+	Active  []*ConversionJob           `json:"active"`
+}
+
+func NewConversionQueue(path string, workers int, handler jobHandler) *ConversionQueue {
+	q := &ConversionQueue{}
+	q.cond = sync.NewCond(&q.mu)
+	q.path = path
+	q.workers = workers
+	q.handler = handler
+	q.pending = make(map[int64][]*ConversionJob)
+	q.active = make(map[int64]*ConversionJob)
+	q.load()
+	return q
+}
+
+func (q *ConversionQueue) Start() {
+	for i := 0; i < q.workers; i++ {
+		go q.work()
+	}
+}
+
+// Push enqueues the job and returns its 1-based position in the queue.
+func (q *ConversionQueue) Push(job *ConversionJob) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.lastId++
+	job.Id = q.lastId
+	job.Status = JobStatusQueued
+	if job.CreatedAt.IsZero() {
+		job.CreatedAt = time.Now()
+	}
+	q.enqueue(job)
+	q.save()
+	q.cond.Signal()
+
+	return q.position(job.Id)
+}
+
+// SetStatus changes the status of an active job. Jobs are saved by other
+// goroutines, so their fields are changed under the lock only.
+func (q *ConversionQueue) SetStatus(job *ConversionJob, status string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	job.Status = status
+	q.save()
+}
+
+// NextPosition returns the position a new job of the chat would get in the queue.
+func (q *ConversionQueue) NextPosition(chatId int64) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	depth := len(q.pending[chatId])
+	position := 1
+	before := true
+	for _, id := range q.chats {
+		if id == chatId {
+			before = false
+		}
+		n := len(q.pending[id])
+		if n > depth {
+			n = depth
+			if before {
+				n++
+			}
+		}
+		position += n
+	}
+	return position
+}
+
+func (q *ConversionQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.order())
+}
+
+func (q *ConversionQueue) enqueue(job *ConversionJob) {
+	if _, ok := q.pending[job.ChatId]; !ok {
+		q.chats = append(q.chats, job.ChatId)
+	}
+	q.pending[job.ChatId] = append(q.pending[job.ChatId], job)
+}
+
+func (q *ConversionQueue) next() *ConversionJob {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for len(q.chats) == 0 {
+		q.cond.Wait()
+	}
+
+	chatId := q.chats[0]
+	q.chats = q.chats[1:]
+	jobs := q.pending[chatId]
+	job := jobs[0]
+	if len(jobs) > 1 {
+		q.pending[chatId] = jobs[1:]
+		q.chats = append(q.chats, chatId)
+	} else {
+		delete(q.pending, chatId)
+	}
+	q.active[job.Id] = job
+	q.save()
+
+	return job
+}
+
+func (q *ConversionQueue) done(job *ConversionJob) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	delete(q.active, job.Id)
+	q.save()
+}
+
+func (q *ConversionQueue) work() {
+	for {
+		job := q.next()
+		q.handler(job)
+		q.done(job)
+	}
+}
+
+// order returns pending jobs in the order workers will take them.
+func (q *ConversionQueue) order() []*ConversionJob {
+	var result []*ConversionJob
+	for depth := 0; ; depth++ {
+		found := false
+		for _, chatId := range q.chats {
+			jobs := q.pending[chatId]
+			if depth < len(jobs) {
+				result = append(result, jobs[depth])
+				found = true
+			}
+		}
+		if !found {
+			return result
+		}
+	}
+}
+
+func (q *ConversionQueue) position(id int64) int {
+	for i, job := range q.order() {
+		if job.Id == id {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+func (q *ConversionQueue) save() {
+	state := conversionQueueState{
+		LastId:  q.lastId,
+		Chats:   q.chats,
+		Pending: q.pending,
+	}
+	for _, job := range q.active {
+		state.Active = append(state.Active, job)
+	}
+
+	b, err := json.Marshal(state)
+	if err != nil {
+		log.Printf("QUEUE SAVE ERR: %s\n", err)
+		return
+	}
+	tmp := q.path + ".tmp"
+	if err = ioutil.WriteFile(tmp, b, 0644); err != nil {
+		log.Printf("QUEUE SAVE ERR: %s\n", err)
+		return
+	}
+	if err = os.Rename(tmp, q.path); err != nil {
+		log.Printf("QUEUE SAVE ERR: %s\n", err)
+	}
+}
+
+func (q *ConversionQueue) load() {
+	b, err := ioutil.ReadFile(q.path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("QUEUE LOAD ERR: %s\n", err)
+		}
+		return
+	}
+
+	state := conversionQueueState{}
+	if err = json.Unmarshal(b, &state); err != nil {
+		log.Printf("QUEUE LOAD ERR: %s\n", err)
+		return
+	}
+
+	q.lastId = state.LastId
+	// Jobs that were in progress when the bot stopped go back to the queue
+	for _, job := range state.Active {
+		job.Status = JobStatusQueued
+		q.enqueue(job)
+	}
+	for _, chatId := range state.Chats {
+		for _, job := range state.Pending[chatId] {
+			q.enqueue(job)
+		}
+	}
+	log.Printf("Restored %d conversion jobs\n", len(q.order()))
+}
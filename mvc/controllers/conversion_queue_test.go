@@ -0,0 +1,40 @@
+package controllers
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestConversionQueueNextPosition(t *testing.T) {
+	tests := []struct {
+		name string
+		// Chats of pushed jobs in order
+		pushed []int64
+		chat   int64
+		want   int
+	}{
+		{"empty queue", nil, 1, 1},
+		{"behind own job", []int64{1}, 1, 2},
+		{"new chat", []int64{1, 1, 1}, 2, 2},
+		{"new chat after others", []int64{1, 2, 1, 3}, 4, 4},
+		{"first chat", []int64{1, 2, 2, 2}, 1, 3},
+		{"last chat", []int64{1, 1, 1, 2}, 2, 4},
+		{"middle chat", []int64{1, 1, 2, 3, 3, 3}, 2, 5},
+		{"many rounds", []int64{1, 1, 1, 2, 2, 2}, 1, 7},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := NewConversionQueue(filepath.Join(t.TempDir(), "queue.json"), 1, nil)
+			for _, chatId := range tt.pushed {
+				q.Push(&ConversionJob{ChatId: chatId})
+			}
+			if got := q.NextPosition(tt.chat); got != tt.want {
+				t.Errorf("Next position is %d, want %d", got, tt.want)
+			}
+			// The job really gets the position
+			if got := q.Push(&ConversionJob{ChatId: tt.chat}); got != tt.want {
+				t.Errorf("Pushed to %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
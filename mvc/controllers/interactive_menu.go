@@ -1,35 +1,58 @@
 package controllers
 
 import (
-	"fmt"
 	"github.com/nolka/gooffroadmaster/mvc"
 	"log"
-	"strings"
+	"sync"
+	"time"
 
 	"github.com/nolka/gooffroadmaster/util"
 	"gopkg.in/telegram-bot-api.v4"
 )
 
+const (
+	cancelCommand = "cancel"
+	// How often idle dialogs are checked
+	menuReapPeriod = time.Minute
+	// Dialogs idle for longer are unloaded, the store keeps them
+	menuIdleEviction = time.Hour
+)
+
 func NewInteractiveMenu(manager *mvc.Router) *InteractiveMenu {
 	c := &InteractiveMenu{}
 	util.LoadConfig(c)
 	c.Init(manager)
+	c.Store = NewFileStateStore(util.MakePath(util.GetRuntimePath(), "menu_state"))
+	go c.reapLoop()
 	return c
 }
 
 type InteractiveMenu struct {
 	Router   *mvc.Router           `json:"-"`
-	Id       int                   `json:"-"`
 	UserList map[int]*StateManager `json:"-"`
+	States   *StateRegistry        `json:"-"`
+	// Optional, dialogs are kept in memory only without it
+	Store StateStore `json:"-"`
+
+	lock sync.Mutex
 }
 
-func (i *InteractiveMenu) SetId(id int) {
-	i.Id = id
+func (i *InteractiveMenu) GetNamespace() string {
+	return "menu"
 }
 
 func (i *InteractiveMenu) Init(manager *mvc.Router) {
 	i.Router = manager
 	i.UserList = make(map[int]*StateManager)
+	i.States = NewStateRegistry()
+	registerHelloDialog(i.States)
+
+	manager.RegisterCommand(&mvc.Command{
+		Name:        cancelCommand,
+		Description: "Прервать текущий диалог",
+		Scope:       mvc.ScopePrivate,
+		Handler:     i.HandleCancelCommand,
+	})
 }
 
 func (i *InteractiveMenu) GetName() string {
@@ -37,30 +60,183 @@ func (i *InteractiveMenu) GetName() string {
 }
 
 func (i *InteractiveMenu) PrepareData(d ...string) string {
-	return fmt.Sprintf("%d|%s", i.Id, strings.Join(d, "|"))
+	return i.Router.CallbackData(i.GetNamespace(), 1, d...)
+}
+
+// UpdateFilter accepts messages in private chats.
+func (i *InteractiveMenu) UpdateFilter() mvc.Filter {
+	return mvc.Filter{Kinds: mvc.KindMessage | mvc.KindCallback, ChatTypes: []string{"private"}}
 }
 
 func (i *InteractiveMenu) HandleMessage(update tgbotapi.Update) {
-	if update.Message.Chat.Type != "private" {
-		return;
-	}
 	userId := update.Message.From.ID
-	s, ok := i.UserList[userId]
-	if !ok {
+	s := i.activeManager(userId)
+	if s == nil {
 		log.Printf("Creating state mgr for user: %d\n", userId)
 		s = InitNewManager(i, nil, update.Message)
-		i.UserList[userId] = s
+		i.setManager(userId, s)
+		// The message only starts the dialog
+		i.persist(userId, s)
+		return
 	}
 	log.Printf("Dispatching state message to user id: %d\n", userId)
 	s.Update(update.Message)
+	i.persist(userId, s)
 }
 
 func (i *InteractiveMenu) HandleCallback(update tgbotapi.Update) {
 	userId := update.CallbackQuery.From.ID
-	s, ok := i.UserList[userId]
-	if !ok {
+	s := i.activeManager(userId)
+	if s == nil {
 		log.Printf("Error handling callback because user id: %d was not requested this action!\n", userId)
+		// The dialog timed out or was cancelled, the button is stale
+		i.Router.AnswerCallback(tgbotapi.NewCallback(update.CallbackQuery.ID, "Диалог завершён, начните заново"))
 		return
 	}
 	s.UpdateCallback(update.CallbackQuery, userId)
+	i.persist(userId, s)
+}
+
+// HandleCancelCommand leaves all states of the user dialog.
+func (i *InteractiveMenu) HandleCancelCommand(message *tgbotapi.Message, args mvc.CommandArgs) {
+	userId := message.From.ID
+	s := i.getManager(userId)
+	if s == nil {
+		i.Router.Send(tgbotapi.NewMessage(message.Chat.ID, "Нечего отменять"))
+		return
+	}
+	s.LastMessage = message
+	s.Cancel()
+	i.persist(userId, s)
+	i.Router.Send(tgbotapi.NewMessage(message.Chat.ID, "Диалог прерван"))
+}
+
+// activeManager returns the user dialog with timed out states left, nil if none are left.
+func (i *InteractiveMenu) activeManager(userId int) *StateManager {
+	s := i.getManager(userId)
+	if s == nil {
+		return nil
+	}
+	s.Expire(time.Now())
+	if len(s.StateStack) == 0 {
+		i.persist(userId, s)
+		return nil
+	}
+	return s
+}
+
+// getManager returns the user dialog, restoring it from the store if it is not loaded yet.
+func (i *InteractiveMenu) getManager(userId int) *StateManager {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	if s, ok := i.UserList[userId]; ok {
+		return s
+	}
+	if i.Store == nil {
+		return nil
+	}
+	b, err := i.Store.Load(userId)
+	if err != nil {
+		log.Printf("Failed to load dialog of user %d: %s\n", userId, err)
+		return nil
+	}
+	if b == nil {
+		return nil
+	}
+	s, err := i.States.Unmarshal(b, i)
+	if err != nil {
+		log.Printf("Failed to restore dialog of user %d: %s\n", userId, err)
+		return nil
+	}
+	log.Printf("Restored dialog of user %d\n", userId)
+	i.UserList[userId] = s
+	return s
+}
+
+func (i *InteractiveMenu) setManager(userId int, s *StateManager) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	i.UserList[userId] = s
+}
+
+// persist saves the user dialog, a finished dialog is forgotten.
+func (i *InteractiveMenu) persist(userId int, s *StateManager) {
+	if len(s.StateStack) == 0 {
+		i.lock.Lock()
+		delete(i.UserList, userId)
+		i.lock.Unlock()
+		if i.Store != nil {
+			if err := i.Store.Delete(userId); err != nil {
+				log.Printf("Failed to delete dialog of user %d: %s\n", userId, err)
+			}
+		}
+		return
+	}
+	if i.Store == nil {
+		return
+	}
+
+	b, err := i.States.Marshal(s)
+	if err == nil {
+		err = i.Store.Save(userId, b)
+	}
+	if err != nil {
+		log.Printf("Failed to save dialog of user %d: %s\n", userId, err)
+	}
+}
+
+// reapLoop times out idle states and unloads idle dialogs. Dialogs are
+// checked in mailboxes of their users, so they do not race with updates.
+func (i *InteractiveMenu) reapLoop() {
+	for range time.Tick(menuReapPeriod) {
+		i.lock.Lock()
+		var users []int
+		for userId := range i.UserList {
+			users = append(users, userId)
+		}
+		i.lock.Unlock()
+
+		for _, userId := range users {
+			userId := userId
+			i.Router.EnqueueJob(userId, func() {
+				i.reap(userId, time.Now())
+			})
+		}
+	}
+}
+
+func (i *InteractiveMenu) reap(userId int, now time.Time) {
+	i.lock.Lock()
+	s, ok := i.UserList[userId]
+	i.lock.Unlock()
+	if !ok {
+		return
+	}
+
+	states := len(s.StateStack)
+	s.Expire(now)
+	if len(s.StateStack) != states {
+		i.persist(userId, s)
+	}
+	if len(s.StateStack) > 0 && now.Sub(s.LastActivity) >= menuIdleEviction {
+		log.Printf("Unloading idle dialog of user %d\n", userId)
+		i.lock.Lock()
+		delete(i.UserList, userId)
+		i.lock.Unlock()
+	}
+}
+
+// Halt saves dialogs of all users.
+func (i *InteractiveMenu) Halt() {
+	i.lock.Lock()
+	users := make(map[int]*StateManager, len(i.UserList))
+	for userId, s := range i.UserList {
+		users[userId] = s
+	}
+	i.lock.Unlock()
+
+	for userId, s := range users {
+		i.persist(userId, s)
+	}
 }
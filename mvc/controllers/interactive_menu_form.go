@@ -0,0 +1,336 @@
+package controllers
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"gopkg.in/telegram-bot-api.v4"
+)
+
+const (
+	formAction       = "form"
+	formChoose       = "choose"
+	formSkip         = "skip"
+	formBack         = "back"
+	formConfirm      = "confirm"
+	formCancel       = "cancel"
+	formChoicesInRow = 3
+	formSkipped      = "—"
+	// Default time to answer a field
+	formTimeout = 15 * time.Minute
+)
+
+// FieldValidator checks the answer and returns the value to keep or an error shown to the user.
+type FieldValidator func(text string) (string, error)
+
+type FormField struct {
+	Name   string
+	Prompt string
+	// Shown in the summary instead of the prompt
+	Label string
+	// The answer must be one of them, they are shown as buttons
+	Choices  []string
+	Optional bool
+	Validate []FieldValidator
+}
+
+// FormValues are answers by field name, skipped optional fields are absent.
+type FormValues map[string]string
+
+type FormSubmitHandler func(manager *StateManager, values FormValues)
+
+// Form is a declarative wizard: it asks fields one by one, lets the user go
+// back or skip optional fields, and shows a summary to confirm. Forms become
+// dialog states with State, so they can be pushed to a StateManager.
+type Form struct {
+	Name     string
+	Title    string
+	Fields   []FormField
+	onSubmit FormSubmitHandler
+	timeout  time.Duration
+}
+
+func NewForm(name, title string) *Form {
+	return &Form{Name: name, Title: title, timeout: formTimeout}
+}
+
+// Text adds a free text field.
+func (f *Form) Text(name, prompt string, validators ...FieldValidator) *Form {
+	f.Fields = append(f.Fields, FormField{Name: name, Prompt: prompt, Validate: validators})
+	return f
+}
+
+// Choice adds a field answered by one of the buttons.
+func (f *Form) Choice(name, prompt string, choices ...string) *Form {
+	f.Fields = append(f.Fields, FormField{Name: name, Prompt: prompt, Choices: choices})
+	return f
+}
+
+// Optional lets the user skip the last added field.
+func (f *Form) Optional() *Form {
+	f.Fields[len(f.Fields)-1].Optional = true
+	return f
+}
+
+// Label sets the summary label of the last added field.
+func (f *Form) Label(label string) *Form {
+	f.Fields[len(f.Fields)-1].Label = label
+	return f
+}
+
+// OnSubmit sets the handler called with the answers after the user confirmed them.
+func (f *Form) OnSubmit(handler FormSubmitHandler) *Form {
+	f.onSubmit = handler
+	return f
+}
+
+// Timeout sets how long the form waits for an answer, zero waits forever.
+func (f *Form) Timeout(timeout time.Duration) *Form {
+	f.timeout = timeout
+	return f
+}
+
+// State makes a dialog state filling the form from the beginning.
+func (f *Form) State(manager *StateManager) StateInterface {
+	return &FormState{Manager: manager, form: f, Values: FormValues{}}
+}
+
+// RegisterForm makes form states restorable after restart.
+func (r *StateRegistry) RegisterForm(form *Form) {
+	r.RegisterState(formStateName(form), form.State)
+}
+
+func formStateName(form *Form) string {
+	return "form:" + form.Name
+}
+
+func MaxLength(n int) FieldValidator {
+	return func(text string) (string, error) {
+		if utf8.RuneCountInString(text) > n {
+			return "", fmt.Errorf("Не больше %d символов", n)
+		}
+		return text, nil
+	}
+}
+
+func IntRange(min, max int) FieldValidator {
+	return func(text string) (string, error) {
+		v, err := strconv.Atoi(strings.TrimSpace(text))
+		if err != nil || v < min || v > max {
+			return "", fmt.Errorf("Введите число от %d до %d", min, max)
+		}
+		return strconv.Itoa(v), nil
+	}
+}
+
+func Pattern(re *regexp.Regexp, hint string) FieldValidator {
+	return func(text string) (string, error) {
+		if !re.MatchString(text) {
+			return "", fmt.Errorf("%s", hint)
+		}
+		return text, nil
+	}
+}
+
+// FormState is the form being filled by a user.
+type FormState struct {
+	Manager *StateManager `json:"-"`
+	form    *Form
+	Step    int
+	Values  FormValues
+}
+
+// Name tells the registry which form the state fills, all forms share the type.
+func (s *FormState) Name() string {
+	return formStateName(s.form)
+}
+
+func (s *FormState) OnEnter(msg *tgbotapi.Message) {
+	s.send(msg, s.form.Title, nil)
+	s.ask(msg)
+}
+
+func (s *FormState) OnExit(msg *tgbotapi.Message) {
+}
+
+func (s *FormState) Timeout() time.Duration {
+	return s.form.timeout
+}
+
+func (s *FormState) OnTimeout(msg *tgbotapi.Message) {
+	s.send(msg, "Время ожидания ответа истекло, заполнение отменено", nil)
+}
+
+func (s *FormState) confirming() bool {
+	return s.Step >= len(s.form.Fields)
+}
+
+func (s *FormState) Update(msg *tgbotapi.Message) {
+	if s.confirming() {
+		s.showSummary(msg)
+		return
+	}
+	field := s.form.Fields[s.Step]
+	value := strings.TrimSpace(msg.Text)
+	if len(field.Choices) > 0 {
+		i := choiceIndex(field.Choices, value)
+		if i < 0 {
+			s.send(msg, "Выберите один из вариантов", nil)
+			s.ask(msg)
+			return
+		}
+		value = field.Choices[i]
+	}
+	for _, validate := range field.Validate {
+		var err error
+		if value, err = validate(value); err != nil {
+			s.send(msg, err.Error(), nil)
+			s.ask(msg)
+			return
+		}
+	}
+	s.answer(msg, value)
+}
+
+// UpdateCallback handles "form|<step>|<action>[|<choice>]" buttons.
+func (s *FormState) UpdateCallback(callback *tgbotapi.CallbackQuery, userId int) {
+	s.Manager.Menu.Router.AnswerCallback(tgbotapi.NewCallback(callback.ID, ""))
+	parts := strings.Split(callback.Data, "|")
+	if len(parts) < 4 || parts[1] != formAction || callback.Message == nil {
+		return
+	}
+	// Buttons of previous steps do nothing
+	if step, err := strconv.Atoi(parts[2]); err != nil || step != s.Step {
+		return
+	}
+	msg := callback.Message
+	s.removeKeyboard(msg)
+
+	switch parts[3] {
+	case formChoose:
+		field := s.form.Fields[s.Step]
+		i, err := strconv.Atoi(parts[len(parts)-1])
+		if err != nil || i < 0 || i >= len(field.Choices) {
+			return
+		}
+		s.answer(msg, field.Choices[i])
+	case formSkip:
+		delete(s.Values, s.form.Fields[s.Step].Name)
+		s.next(msg)
+	case formBack:
+		if s.Step > 0 {
+			s.Step--
+		}
+		s.ask(msg)
+	case formConfirm:
+		// Popped first, so the handler can go on with the next state
+		s.Manager.PopState()
+		if s.form.onSubmit != nil {
+			s.form.onSubmit(s.Manager, s.Values)
+		}
+	case formCancel:
+		s.send(msg, "Отменено", nil)
+		s.Manager.PopState()
+	}
+}
+
+func (s *FormState) answer(msg *tgbotapi.Message, value string) {
+	s.Values[s.form.Fields[s.Step].Name] = value
+	s.next(msg)
+}
+
+func (s *FormState) next(msg *tgbotapi.Message) {
+	s.Step++
+	s.ask(msg)
+}
+
+func (s *FormState) ask(msg *tgbotapi.Message) {
+	if s.confirming() {
+		s.showSummary(msg)
+		return
+	}
+
+	field := s.form.Fields[s.Step]
+	var rows [][]tgbotapi.InlineKeyboardButton
+	var row []tgbotapi.InlineKeyboardButton
+	for i, choice := range field.Choices {
+		row = append(row, tgbotapi.NewInlineKeyboardButtonData(choice, s.data(formChoose, strconv.Itoa(i))))
+		if len(row) == formChoicesInRow {
+			rows = append(rows, row)
+			row = nil
+		}
+	}
+	if len(row) > 0 {
+		rows = append(rows, row)
+	}
+
+	var controls []tgbotapi.InlineKeyboardButton
+	if s.Step > 0 {
+		controls = append(controls, tgbotapi.NewInlineKeyboardButtonData("« Назад", s.data(formBack)))
+	}
+	if field.Optional {
+		controls = append(controls, tgbotapi.NewInlineKeyboardButtonData("Пропустить", s.data(formSkip)))
+	}
+	controls = append(controls, tgbotapi.NewInlineKeyboardButtonData("Отмена", s.data(formCancel)))
+	rows = append(rows, controls)
+
+	markup := tgbotapi.NewInlineKeyboardMarkup(rows...)
+	s.send(msg, field.Prompt, &markup)
+}
+
+func (s *FormState) showSummary(msg *tgbotapi.Message) {
+	b := &strings.Builder{}
+	b.WriteString(s.form.Title + "\n\n")
+	for _, field := range s.form.Fields {
+		value, ok := s.Values[field.Name]
+		if !ok {
+			value = formSkipped
+		}
+		label := field.Label
+		if label == "" {
+			label = field.Prompt
+		}
+		fmt.Fprintf(b, "%s: %s\n", label, value)
+	}
+	b.WriteString("\nВсё верно?")
+
+	markup := tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("Подтвердить", s.data(formConfirm)),
+			tgbotapi.NewInlineKeyboardButtonData("« Назад", s.data(formBack)),
+			tgbotapi.NewInlineKeyboardButtonData("Отмена", s.data(formCancel)),
+		),
+	)
+	s.send(msg, b.String(), &markup)
+}
+
+func (s *FormState) data(action string, args ...string) string {
+	return s.Manager.Menu.PrepareData(append([]string{formAction, strconv.Itoa(s.Step), action}, args...)...)
+}
+
+func (s *FormState) send(msg *tgbotapi.Message, text string, markup *tgbotapi.InlineKeyboardMarkup) {
+	m := tgbotapi.NewMessage(msg.Chat.ID, text)
+	if markup != nil {
+		m.ReplyMarkup = *markup
+	}
+	s.Manager.Menu.Router.Send(m)
+}
+
+// removeKeyboard takes buttons off the answered prompt.
+func (s *FormState) removeKeyboard(msg *tgbotapi.Message) {
+	empty := tgbotapi.InlineKeyboardMarkup{InlineKeyboard: [][]tgbotapi.InlineKeyboardButton{}}
+	s.Manager.Menu.Router.Send(tgbotapi.NewEditMessageReplyMarkup(msg.Chat.ID, msg.MessageID, empty))
+}
+
+func choiceIndex(choices []string, text string) int {
+	for i, choice := range choices {
+		if strings.EqualFold(choice, text) {
+			return i
+		}
+	}
+	return -1
+}
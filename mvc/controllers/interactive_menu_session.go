@@ -0,0 +1,53 @@
+package controllers
+
+import (
+	"encoding/json"
+	"log"
+)
+
+// Session keeps values of a dialog or of a state by key. Values are kept as
+// JSON, so they are saved with the dialog as they are and read back into
+// their types by Get.
+type Session struct {
+	Values map[string]json.RawMessage `json:"values,omitempty"`
+}
+
+func NewSession() *Session {
+	return &Session{Values: make(map[string]json.RawMessage)}
+}
+
+func (s *Session) Has(name string) bool {
+	_, ok := s.Values[name]
+	return ok
+}
+
+func (s *Session) Delete(name string) {
+	delete(s.Values, name)
+}
+
+// Get returns the value, def if it is not set or was saved as another type.
+// Values are copies: change one and Set it back.
+func Get[T any](s *Session, name string, def T) T {
+	b, ok := s.Values[name]
+	if !ok {
+		return def
+	}
+	var v T
+	if err := json.Unmarshal(b, &v); err != nil {
+		log.Printf("Session value %s is not a %T: %s\n", name, v, err)
+		return def
+	}
+	return v
+}
+
+func Set[T any](s *Session, name string, v T) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		log.Printf("Failed to set session value %s: %s\n", name, err)
+		return
+	}
+	if s.Values == nil {
+		s.Values = make(map[string]json.RawMessage)
+	}
+	s.Values[name] = b
+}
@@ -3,103 +3,42 @@ package controllers
 import (
 	"fmt"
 	"gopkg.in/telegram-bot-api.v4"
-	"log"
-	"strconv"
-	"strings"
 )
 
-type HelloState struct {
-	Manager   *StateManager
-	Reg *RegistrationInfo
-	LastName  string
-	msgCount  int
+// registrationForm asks the name and goes on to EnterOne.
+var registrationForm = NewForm("registration", "Okay! Hello there!").
+	Text("first_name", "Please, enter your first name", MaxLength(64)).Label("First name").
+	Text("last_name", "Please, enter your last name", MaxLength(64)).Label("Last name").
+	OnSubmit(func(m *StateManager, values FormValues) {
+		Set(m.Session, registrationKey, RegistrationInfo{
+			FirstName: values["first_name"],
+			LastName:  values["last_name"],
+			Approved:  true,
+		})
+		m.SetState(&EnterOne{m})
+	})
+
+const registrationKey = "registration"
+
+func registerHelloDialog(r *StateRegistry) {
+	r.RegisterForm(registrationForm)
+	r.RegisterState("enter_one", func(m *StateManager) StateInterface {
+		return &EnterOne{Manager: m}
+	})
 }
 
 type RegistrationInfo struct {
 	FirstName string
-	LastName string
-	Approved bool
-}
-
-func (s *HelloState) OnEnter(msg *tgbotapi.Message) {
-	if msg.Chat.Type != "private"{
-		return;
-	}
-	s.Say("Okay! Hello there!", msg)
-	s.Reg = &RegistrationInfo{}
-}
-
-func (s *HelloState) OnExit(msg *tgbotapi.Message) {
-}
-
-func (s *HelloState) PrepareData(d ...string) string {
-	return fmt.Sprintf("%d|%s", s.Manager.Menu.Id, strings.Join(d, "|"))
-}
-
-
-func (s *HelloState) Update(msg *tgbotapi.Message) {
-	if s.msgCount == 0 {
-		s.Say("Please, enter your first name", msg)
-		s.msgCount++
-		return
-	}
-	if s.Reg.FirstName == "" {
-		s.Reg.FirstName = msg.Text
-		s.Say("Please, enter Last Name!", msg)
-		return
-	}
-
-	if s.Reg.LastName == "" {
-		s.Reg.LastName = msg.Text
-	}
-
-	if !s.Reg.Approved {
-		s.QueryConfirmation(msg)
-	}
-
-	log.Printf("HELO: %s\n", msg)
-}
-
-func (s *HelloState) QueryConfirmation(msg *tgbotapi.Message) {
-	markup := tgbotapi.NewInlineKeyboardMarkup(
-		tgbotapi.NewInlineKeyboardRow(
-			tgbotapi.NewInlineKeyboardButtonData("Confirm", s.PrepareData(strconv.Itoa(msg.From.ID), "yes")),
-			tgbotapi.NewInlineKeyboardButtonData("Cancel", s.PrepareData(strconv.Itoa(msg.From.ID), "no")),
-		),
-	)
-	c := tgbotapi.NewMessage(msg.Chat.ID, "")
-	c.ReplyToMessageID = msg.MessageID
-	c.ReplyMarkup = markup
-	c.Text = "Confirm registration"
-	s.Manager.Menu.Router.Results <- c
-}
-
-func (s *HelloState) UpdateCallback(msg *tgbotapi.CallbackQuery, userId int) {
-	parts := strings.Split(msg.Data, "|")
-
-	if parts[2] == "yes" {
-		ns := &EnterOne{s.Manager}
-		s.Manager.SaveData(s.Reg)
-		s.Manager.SetState(ns)
-		return
-	} else {
-		s.Say("Registration aborted", msg.Message)
-	}
-}
-
-func (s *HelloState) Say(text string, prevMsg *tgbotapi.Message) {
-	msg := tgbotapi.NewMessage(prevMsg.Chat.ID, text)
-	s.Manager.Menu.Router.Results <- msg
+	LastName  string
+	Approved  bool
 }
 
 type EnterOne struct {
-	Manager *StateManager
+	Manager *StateManager `json:"-"`
 }
 
-type HelloStateFactory func() StateInterface
-
 func (s *EnterOne) OnEnter(msg *tgbotapi.Message) {
-	var reg *RegistrationInfo = s.Manager.GetSavedData().(*RegistrationInfo)
+	reg := Get(s.Manager.Session, registrationKey, RegistrationInfo{})
 
 	s.Query(fmt.Sprintf("%s %s, You are in EnterOne journey world!!", reg.FirstName, reg.LastName), msg)
 }
@@ -121,5 +60,5 @@ func (s *EnterOne) UpdateCallback(msg *tgbotapi.CallbackQuery, userId int) {
 
 func (s *EnterOne) Query(text string, prevMsg *tgbotapi.Message) {
 	msg := tgbotapi.NewMessage(prevMsg.Chat.ID, text)
-	s.Manager.Menu.Router.Results <- msg
+	s.Manager.Menu.Router.Send(msg)
 }
@@ -1,6 +1,8 @@
 package controllers
 
 import (
+	"time"
+
 	"gopkg.in/telegram-bot-api.v4"
 )
 
@@ -11,31 +13,42 @@ type StateInterface interface {
 	UpdateCallback(callback *tgbotapi.CallbackQuery, userId int)
 }
 
+// TimeoutState is implemented by states which are left after the user is
+// inactive for Timeout. OnTimeout is called before the state is popped.
+type TimeoutState interface {
+	Timeout() time.Duration
+	OnTimeout(msg *tgbotapi.Message)
+}
+
+// StateManager keeps the dialog of one user. It is not locked: the router
+// handles updates of a user one by one, see mvc.Router.Enqueue.
 type StateManager struct {
 	Menu        *InteractiveMenu
 	StateStack  []StateInterface
 	LastMessage *tgbotapi.Message
-	SavedData   interface{}
+	// Values kept while the dialog lasts
+	Session *Session
+	// Values of states in the stack, dropped with their states
+	StateSessions []*Session
+	// Time of the last update from the user
+	LastActivity time.Time
 }
 
 func InitNewManager(menu *InteractiveMenu, initState *StateInterface, lastMessage *tgbotapi.Message) *StateManager {
 	mgr := &StateManager{}
 	mgr.Menu = menu
 	mgr.LastMessage = lastMessage
+	mgr.Session = NewSession()
+	mgr.LastActivity = time.Now()
 	if initState == nil {
-		s := new(HelloState)
-		s.Manager = mgr
-		mgr.SetState(s)
+		mgr.SetState(registrationForm.State(mgr))
 	}
 	return mgr
 }
 
-func (s *StateManager) SaveData(data interface{}) {
-	s.SavedData = data
-}
-
-func (s *StateManager) GetSavedData() interface{} {
-	return s.SavedData
+// StateSession returns values of the current state, OnEnter and OnExit see them as well.
+func (s *StateManager) StateSession() *Session {
+	return s.StateSessions[len(s.StateSessions)-1]
 }
 
 func (s *StateManager) GetState() StateInterface {
@@ -43,6 +56,7 @@ func (s *StateManager) GetState() StateInterface {
 }
 
 func (s *StateManager) SetState(state StateInterface) {
+	s.StateSessions = append(s.StateSessions, NewSession())
 	state.OnEnter(s.LastMessage)
 	s.StateStack = append(s.StateStack, state)
 }
@@ -51,14 +65,37 @@ func (s *StateManager) PopState() StateInterface {
 	si := s.StateStack[len(s.StateStack)-1]
 	si.OnExit(s.LastMessage)
 	s.StateStack = s.StateStack[:len(s.StateStack)-1]
+	s.StateSessions = s.StateSessions[:len(s.StateSessions)-1]
 	return si
 }
 
+// Expire pops the states which have timed out by now, the top one first.
+func (s *StateManager) Expire(now time.Time) {
+	idle := now.Sub(s.LastActivity)
+	for len(s.StateStack) > 0 {
+		state, ok := s.GetState().(TimeoutState)
+		if !ok || state.Timeout() <= 0 || idle < state.Timeout() {
+			return
+		}
+		state.OnTimeout(s.LastMessage)
+		s.PopState()
+	}
+}
+
+// Cancel pops all states.
+func (s *StateManager) Cancel() {
+	for len(s.StateStack) > 0 {
+		s.PopState()
+	}
+}
+
 func (s *StateManager) Update(msg *tgbotapi.Message) {
 	s.LastMessage = msg
+	s.LastActivity = time.Now()
 	s.GetState().Update(msg)
 }
 
 func (s *StateManager) UpdateCallback(msg *tgbotapi.CallbackQuery, userId int) {
+	s.LastActivity = time.Now()
 	s.GetState().UpdateCallback(msg, userId)
 }
@@ -0,0 +1,173 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"time"
+
+	"gopkg.in/telegram-bot-api.v4"
+)
+
+// StateFactory creates an empty state bound to the manager.
+type StateFactory func(manager *StateManager) StateInterface
+
+// NamedState is implemented by states of one type registered under several
+// names, like forms. They tell the name they were registered with.
+type NamedState interface {
+	Name() string
+}
+
+// StateRegistry maps state types to names, so dialogs can be saved as names
+// with JSON payloads and restored later. States are restored by unmarshalling
+// the payload into a state made by the factory.
+type StateRegistry struct {
+	states    map[string]StateFactory
+	typeNames map[reflect.Type]string
+}
+
+func NewStateRegistry() *StateRegistry {
+	r := &StateRegistry{}
+	r.states = make(map[string]StateFactory)
+	r.typeNames = make(map[reflect.Type]string)
+	return r
+}
+
+func (r *StateRegistry) RegisterState(name string, factory StateFactory) {
+	r.states[name] = factory
+	if _, named := factory(nil).(NamedState); !named {
+		r.typeNames[reflect.TypeOf(factory(nil))] = name
+	}
+}
+
+type stateRecord struct {
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload"`
+	Session *Session        `json:"session,omitempty"`
+}
+
+type managerRecord struct {
+	Stack        []stateRecord     `json:"stack"`
+	Session      *Session          `json:"session"`
+	LastMessage  *tgbotapi.Message `json:"last_message"`
+	LastActivity time.Time         `json:"last_activity"`
+}
+
+func (r *StateRegistry) record(v interface{}) (stateRecord, error) {
+	var name string
+	var ok bool
+	if named, isNamed := v.(NamedState); isNamed {
+		name = named.Name()
+		_, ok = r.states[name]
+	} else {
+		name, ok = r.typeNames[reflect.TypeOf(v)]
+	}
+	if !ok {
+		return stateRecord{}, fmt.Errorf("Type %T is not registered", v)
+	}
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return stateRecord{}, err
+	}
+	return stateRecord{Type: name, Payload: payload}, nil
+}
+
+// Marshal saves the state stack and sessions of the manager.
+func (r *StateRegistry) Marshal(manager *StateManager) ([]byte, error) {
+	rec := managerRecord{Session: manager.Session, LastMessage: manager.LastMessage, LastActivity: manager.LastActivity}
+	for n, state := range manager.StateStack {
+		sr, err := r.record(state)
+		if err != nil {
+			return nil, err
+		}
+		sr.Session = manager.StateSessions[n]
+		rec.Stack = append(rec.Stack, sr)
+	}
+	return json.Marshal(rec)
+}
+
+// Unmarshal restores the manager without calling OnEnter of restored states.
+func (r *StateRegistry) Unmarshal(b []byte, menu *InteractiveMenu) (*StateManager, error) {
+	rec := managerRecord{}
+	if err := json.Unmarshal(b, &rec); err != nil {
+		return nil, err
+	}
+
+	manager := &StateManager{Menu: menu, Session: rec.Session, LastMessage: rec.LastMessage, LastActivity: rec.LastActivity}
+	if manager.Session == nil {
+		manager.Session = NewSession()
+	}
+	// Saved before activity was recorded
+	if manager.LastActivity.IsZero() {
+		manager.LastActivity = time.Now()
+	}
+	for _, sr := range rec.Stack {
+		factory, ok := r.states[sr.Type]
+		if !ok {
+			return nil, fmt.Errorf("Unknown state %s", sr.Type)
+		}
+		state := factory(manager)
+		if err := json.Unmarshal(sr.Payload, state); err != nil {
+			return nil, err
+		}
+		manager.StateStack = append(manager.StateStack, state)
+		if sr.Session == nil {
+			sr.Session = NewSession()
+		}
+		manager.StateSessions = append(manager.StateSessions, sr.Session)
+	}
+	if len(manager.StateStack) == 0 {
+		return nil, fmt.Errorf("State stack is empty")
+	}
+	return manager, nil
+}
+
+// StateStore keeps serialized dialogs of users.
+type StateStore interface {
+	// Load returns nil without an error if nothing is saved for the user
+	Load(userId int) ([]byte, error)
+	Save(userId int, data []byte) error
+	Delete(userId int) error
+}
+
+// FileStateStore keeps every user dialog in its own file in the directory.
+type FileStateStore struct {
+	Dir string
+}
+
+func NewFileStateStore(dir string) *FileStateStore {
+	os.MkdirAll(dir, os.ModePerm)
+	return &FileStateStore{Dir: dir}
+}
+
+func (s *FileStateStore) path(userId int) string {
+	return filepath.Join(s.Dir, strconv.Itoa(userId)+".json")
+}
+
+func (s *FileStateStore) Load(userId int) ([]byte, error) {
+	b, err := ioutil.ReadFile(s.path(userId))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return b, err
+}
+
+func (s *FileStateStore) Save(userId int, data []byte) error {
+	tmp := s.path(userId) + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path(userId))
+}
+
+func (s *FileStateStore) Delete(userId int) error {
+	err := os.Remove(s.path(userId))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,56 @@
+package controllers
+
+import (
+	"testing"
+)
+
+func TestStateRegistryRestoresForms(t *testing.T) {
+	vehicle := NewForm("vehicle", "Vehicle").Text("model", "Model").Text("year", "Year")
+	ride := NewForm("ride", "Ride").Text("date", "Date").Text("seats", "Seats")
+	r := NewStateRegistry()
+	r.RegisterForm(vehicle)
+	r.RegisterForm(ride)
+
+	tests := []struct {
+		form   *Form
+		step   int
+		values FormValues
+	}{
+		{vehicle, 1, FormValues{"model": "UAZ"}},
+		{ride, 2, FormValues{"date": "01.06", "seats": "3"}},
+	}
+	for _, test := range tests {
+		state := test.form.State(nil).(*FormState)
+		state.Step = test.step
+		state.Values = test.values
+		manager := &StateManager{
+			Session:       NewSession(),
+			StateStack:    []StateInterface{state},
+			StateSessions: []*Session{NewSession()},
+		}
+
+		b, err := r.Marshal(manager)
+		if err != nil {
+			t.Fatalf("%s: %s", test.form.Name, err)
+		}
+		restored, err := r.Unmarshal(b, &InteractiveMenu{})
+		if err != nil {
+			t.Fatalf("%s: %s", test.form.Name, err)
+		}
+		got, ok := restored.GetState().(*FormState)
+		if !ok {
+			t.Fatalf("%s: restored %T", test.form.Name, restored.GetState())
+		}
+		if got.form != test.form {
+			t.Errorf("%s: restored as form %s", test.form.Name, got.form.Name)
+		}
+		if got.Step != test.step || len(got.Values) != len(test.values) {
+			t.Errorf("%s: restored step %d values %v", test.form.Name, got.Step, got.Values)
+		}
+		for k, v := range test.values {
+			if got.Values[k] != v {
+				t.Errorf("%s: %s restored as %q", test.form.Name, k, got.Values[k])
+			}
+		}
+	}
+}
@@ -0,0 +1,229 @@
+package controllers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const resultCacheIndex = "index.json"
+
+// CachedResult is a conversion result that was already sent to Telegram.
+type CachedResult struct {
+	Key string `json:"key"`
+	// Telegram file_id of the uploaded result, it can be sent again without uploading
+	ResultFileId string `json:"result_file_id"`
+	FileName     string `json:"file_name"`
+	// sha256 of the result content, the name of the file in the cache directory
+	Hash      string    `json:"hash"`
+	Size      int64     `json:"size"`
+	CreatedAt time.Time `json:"created_at"`
+	UsedAt    time.Time `json:"used_at"`
+}
+
+// ResultCache keeps conversion results keyed by source file and conversion
+// options. Contents are stored by hash, so equal results share one file.
+// Entries older than maxAge are dropped and least recently used entries are
+// evicted once the total size exceeds maxSize.
+type ResultCache struct {
+	mu      sync.Mutex
+	dir     string
+	maxSize int64
+	maxAge  time.Duration
+	entries map[string]*CachedResult
+}
+
+// ResultCacheKey builds the cache key. Telegram file_id of a document doesn't
+// change for the bot, so it identifies the source file.
+func ResultCacheKey(fileID string, options ...string) string {
+	return fileID + "|" + strings.Join(options, "|")
+}
+
+func NewResultCache(dir string, maxSize int64, maxAge time.Duration) *ResultCache {
+	c := &ResultCache{}
+	c.dir = dir
+	c.maxSize = maxSize
+	c.maxAge = maxAge
+	c.entries = make(map[string]*CachedResult)
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		log.Printf("CACHE ERR: %s\n", err)
+	}
+	c.load()
+	return c
+}
+
+// Get returns the cached result and marks it as recently used.
+func (c *ResultCache) Get(key string) (*CachedResult, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if c.expired(entry) {
+		c.remove(entry)
+		c.save()
+		return nil, false
+	}
+	entry.UsedAt = time.Now()
+	c.save()
+	result := *entry
+	return &result, true
+}
+
+// Content reads the stored result, it is used when the Telegram file_id is no longer valid.
+func (c *ResultCache) Content(entry *CachedResult) ([]byte, error) {
+	return ioutil.ReadFile(filepath.Join(c.dir, entry.Hash))
+}
+
+// Put stores the result sent to Telegram as resultFileId.
+func (c *ResultCache) Put(key, fileName string, content []byte, resultFileId string) {
+	sum := sha256.Sum256(content)
+	hash := hex.EncodeToString(sum[:])
+	if int64(len(content)) > c.maxSize {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Removed first, otherwise the content file shared with the new entry could be deleted
+	if old, ok := c.entries[key]; ok {
+		c.remove(old)
+	}
+	blob := filepath.Join(c.dir, hash)
+	if _, err := os.Stat(blob); os.IsNotExist(err) {
+		if err = ioutil.WriteFile(blob, content, 0644); err != nil {
+			log.Printf("CACHE WRITE ERR: %s\n", err)
+			return
+		}
+	}
+
+	now := time.Now()
+	c.entries[key] = &CachedResult{
+		Key:          key,
+		ResultFileId: resultFileId,
+		FileName:     fileName,
+		Hash:         hash,
+		Size:         int64(len(content)),
+		CreatedAt:    now,
+		UsedAt:       now,
+	}
+	c.evict()
+	c.save()
+}
+
+// Forget drops the entry, e.g. when its Telegram file_id was rejected.
+func (c *ResultCache) Forget(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if entry, ok := c.entries[key]; ok {
+		c.remove(entry)
+		c.save()
+	}
+}
+
+func (c *ResultCache) expired(entry *CachedResult) bool {
+	return c.maxAge > 0 && time.Since(entry.CreatedAt) > c.maxAge
+}
+
+// remove deletes the entry and its content file unless another entry shares it.
+func (c *ResultCache) remove(entry *CachedResult) {
+	delete(c.entries, entry.Key)
+	for _, e := range c.entries {
+		if e.Hash == entry.Hash {
+			return
+		}
+	}
+	if err := os.Remove(filepath.Join(c.dir, entry.Hash)); err != nil && !os.IsNotExist(err) {
+		log.Printf("CACHE REMOVE ERR: %s\n", err)
+	}
+}
+
+func (c *ResultCache) evict() {
+	var entries []*CachedResult
+	for _, e := range c.entries {
+		if c.expired(e) {
+			c.remove(e)
+			continue
+		}
+		entries = append(entries, e)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].UsedAt.Before(entries[j].UsedAt)
+	})
+	for len(entries) > 0 && c.size() > c.maxSize {
+		c.remove(entries[0])
+		entries = entries[1:]
+	}
+}
+
+// size counts every content file once.
+func (c *ResultCache) size() int64 {
+	var total int64
+	seen := make(map[string]bool)
+	for _, e := range c.entries {
+		if !seen[e.Hash] {
+			seen[e.Hash] = true
+			total += e.Size
+		}
+	}
+	return total
+}
+
+func (c *ResultCache) save() {
+	var entries []*CachedResult
+	for _, e := range c.entries {
+		entries = append(entries, e)
+	}
+
+	b, err := json.Marshal(entries)
+	if err != nil {
+		log.Printf("CACHE SAVE ERR: %s\n", err)
+		return
+	}
+	path := filepath.Join(c.dir, resultCacheIndex)
+	tmp := path + ".tmp"
+	if err = ioutil.WriteFile(tmp, b, 0644); err != nil {
+		log.Printf("CACHE SAVE ERR: %s\n", err)
+		return
+	}
+	if err = os.Rename(tmp, path); err != nil {
+		log.Printf("CACHE SAVE ERR: %s\n", err)
+	}
+}
+
+func (c *ResultCache) load() {
+	b, err := ioutil.ReadFile(filepath.Join(c.dir, resultCacheIndex))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("CACHE LOAD ERR: %s\n", err)
+		}
+		return
+	}
+
+	var entries []*CachedResult
+	if err = json.Unmarshal(b, &entries); err != nil {
+		log.Printf("CACHE LOAD ERR: %s\n", err)
+		return
+	}
+	for _, e := range entries {
+		if _, err := os.Stat(filepath.Join(c.dir, e.Hash)); err != nil {
+			continue
+		}
+		c.entries[e.Key] = e
+	}
+	c.evict()
+	log.Printf("Restored %d cached conversion results\n", len(c.entries))
+}
@@ -0,0 +1,81 @@
+package controllers
+
+import (
+	"io/ioutil"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+type cacheStep struct {
+	// "put", "get" or "age"
+	op      string
+	key     string
+	content string
+}
+
+func TestResultCacheEviction(t *testing.T) {
+	tests := []struct {
+		name  string
+		steps []cacheStep
+		want  []string
+	}{
+		{"fits", []cacheStep{{"put", "a", "1111"}, {"put", "b", "2222"}}, []string{"a", "b"}},
+		{"evicts least recently used", []cacheStep{{"put", "a", "1111"}, {"put", "b", "2222"}, {"put", "c", "3333"}}, []string{"b", "c"}},
+		{"get renews", []cacheStep{{"put", "a", "1111"}, {"put", "b", "2222"}, {"get", "a", ""}, {"put", "c", "3333"}}, []string{"a", "c"}},
+		{"shared content counted once", []cacheStep{{"put", "a", "1111"}, {"put", "b", "1111"}, {"put", "c", "2222"}}, []string{"a", "b", "c"}},
+		{"shared content evicted with all keys", []cacheStep{{"put", "a", "1111"}, {"put", "b", "1111"}, {"put", "c", "2222"}, {"put", "d", "3333"}}, []string{"c", "d"}},
+		{"too large", []cacheStep{{"put", "a", "1111"}, {"put", "b", "12345678901"}}, []string{"a"}},
+		{"replaced", []cacheStep{{"put", "a", "1111"}, {"put", "b", "2222"}, {"put", "a", "33333333"}}, []string{"a"}},
+		{"expired", []cacheStep{{"put", "a", "1111"}, {"age", "a", ""}, {"put", "b", "2222"}}, []string{"b"}},
+		{"expired on get", []cacheStep{{"put", "a", "1111"}, {"age", "a", ""}, {"get", "a", ""}}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			c := NewResultCache(dir, 10, time.Hour)
+			// Steps happen a second apart, so recent use doesn't depend on the clock
+			clock := time.Now().Add(-time.Minute)
+			for _, step := range tt.steps {
+				switch step.op {
+				case "put":
+					c.Put(step.key, step.key+".gpx", []byte(step.content), "file-"+step.key)
+				case "get":
+					c.Get(step.key)
+				case "age":
+					c.entries[step.key].CreatedAt = time.Now().Add(-2 * time.Hour)
+				}
+				if e, ok := c.entries[step.key]; ok && step.op != "age" {
+					e.UsedAt = clock
+				}
+				clock = clock.Add(time.Second)
+			}
+
+			var got []string
+			hashes := make(map[string]bool)
+			for key, e := range c.entries {
+				got = append(got, key)
+				hashes[e.Hash] = true
+				if _, err := c.Content(e); err != nil {
+					t.Errorf("Content of %s is lost: %s", key, err)
+				}
+			}
+			sort.Strings(got)
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("Cached %v, want %v", got, tt.want)
+			}
+
+			// Content files of evicted entries are removed
+			files, err := ioutil.ReadDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, f := range files {
+				if f.Name() != resultCacheIndex && !hashes[f.Name()] {
+					t.Errorf("File %s is left", f.Name())
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,155 @@
+package controllers
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/nolka/gooffroadmaster/track"
+	"github.com/nolka/gooffroadmaster/util"
+)
+
+const (
+	archiveExt = ".zip"
+	// Limits protecting from zip bombs
+	archiveMaxEntries   = 200
+	archiveMaxEntrySize = 50 << 20
+)
+
+func IsArchive(fileName string) bool {
+	return strings.ToLower(path.Ext(fileName)) == archiveExt
+}
+
+// processArchiveJob converts every known track inside the zip and replies with
+// a zip of results and a list of entries that failed.
+func (t *TrackConverter) processArchiveJob(job *ConversionJob, srcFileName string, options track.SimplifyOptions) {
+	archive, err := zip.OpenReader(srcFileName)
+	if err != nil {
+		log.Printf("Failed to open archive: %s\n", err)
+		t.failJob(job, NewConversionError(ErrParseFailed, job.FileName, err))
+		return
+	}
+	defer archive.Close()
+
+	workDir, err := ioutil.TempDir(t.RuntimeDir, "batch")
+	if err != nil {
+		log.Printf("Failed to create batch dir: %s\n", err)
+		t.failJob(job, err)
+		return
+	}
+	defer os.RemoveAll(workDir)
+
+	result := &bytes.Buffer{}
+	out := zip.NewWriter(result)
+	converted := 0
+	files := 0
+	var failures []string
+
+	for _, entry := range archive.File {
+		if entry.FileInfo().IsDir() {
+			continue
+		}
+		if files >= archiveMaxEntries {
+			failures = append(failures, fmt.Sprintf("остальные файлы: в архиве больше %d файлов", archiveMaxEntries))
+			break
+		}
+		files++
+		ext := strings.ToLower(path.Ext(entry.Name))
+		if !t.IsKnownFormat(ext) {
+			failures = append(failures, entry.Name+": неизвестный формат")
+			continue
+		}
+
+		content, err := t.convertArchiveEntry(entry, filepath.Join(workDir, strconv.Itoa(files)), job.DestFormat, options)
+		if err != nil {
+			log.Printf("Failed to convert %s: %s\n", entry.Name, err)
+			failures = append(failures, AsConversionError(err, entry.Name).UserMessage())
+			continue
+		}
+
+		w, err := out.Create(strings.TrimSuffix(entry.Name, path.Ext(entry.Name)) + job.DestFormat)
+		if err == nil {
+			_, err = w.Write(content)
+		}
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %s", entry.Name, err))
+			continue
+		}
+		converted++
+	}
+	if err = out.Close(); err != nil {
+		log.Printf("Failed to pack results: %s\n", err)
+		t.failJob(job, err)
+		return
+	}
+
+	summary := fmt.Sprintf("Сконвертировано файлов: %d", converted)
+	if len(failures) > 0 {
+		summary += "\nНе удалось сконвертировать:\n" + strings.Join(failures, "\n")
+	}
+	if r := []rune(summary); len(r) > maxMessageLength {
+		summary = string(r[:maxMessageLength]) + "..."
+	}
+
+	if converted > 0 {
+		t.setJobStatus(job, JobStatusUploading, "Отправляю результат...")
+		name := strings.TrimSuffix(job.FileName, path.Ext(job.FileName)) + "_" + strings.TrimPrefix(job.DestFormat, ".") + archiveExt
+		if err = t.sendResult(job, name, result.Bytes()); err != nil {
+			t.failJob(job, err)
+			return
+		}
+	}
+	t.editJobMessage(job, summary)
+}
+
+func (t *TrackConverter) convertArchiveEntry(entry *zip.File, dir, destFormat string, options track.SimplifyOptions) ([]byte, error) {
+	if entry.UncompressedSize64 > archiveMaxEntrySize {
+		return nil, fmt.Errorf("файл больше %d МБ", archiveMaxEntrySize>>20)
+	}
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, err
+	}
+	// The result is kept in memory, so files of the entry are not needed after it
+	defer os.RemoveAll(dir)
+
+	// Only the base name is used, so entries can't escape the work dir
+	srcFileName := util.MakePath(dir, path.Base(entry.Name))
+	if err := extractEntry(entry, srcFileName); err != nil {
+		return nil, err
+	}
+	if strings.EqualFold(path.Ext(srcFileName), destFormat) {
+		return ioutil.ReadFile(srcFileName)
+	}
+
+	newFileName, err := t.convert(srcFileName, destFormat, options, t.getConverter())
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(newFileName)
+	return ioutil.ReadFile(newFileName)
+}
+
+func extractEntry(entry *zip.File, dest string) error {
+	r, err := entry.Open()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	f, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = io.Copy(f, io.LimitReader(r, archiveMaxEntrySize))
+	return err
+}
@@ -4,24 +4,49 @@ import (
 	"bytes"
 	"fmt"
 	"github.com/nolka/gooffroadmaster/mvc"
+	"io/ioutil"
 	"log"
 	"os"
 	"os/exec"
 	"path"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
-	"github.com/nolka/gogpslib"
-	"github.com/nolka/gogpslib/writer"
+	"github.com/nolka/gooffroadmaster/track"
 	"github.com/nolka/gooffroadmaster/util"
 	"gopkg.in/telegram-bot-api.v4"
 )
 
 const (
-	defaultConverterID = 2
+	trackConverterNamespace = "conv"
+	// Bump when callback data layout changes, so old buttons are answered as expired
+	trackConverterCallbackVersion = 1
 )
 
-type conversionCallback func(srcFile string, destFormat string) (string, error)
+const (
+	internalConverterID = 1
+	gpsbabelConverterID = 2
+	defaultConverterID  = gpsbabelConverterID
+	buttonsPerRow       = 3
+	statisticsAction    = "stats"
+	mapAction           = "map"
+	profileAction       = "profile"
+	profileCommand      = "profile"
+	backAction          = "back"
+	actionQueuedText    = "Запрос добавлен в очередь"
+	formatsKeyboardText = "Могу сконвертировать этот файл в один из следующих форматов:"
+	// Telegram limit for callback answer text
+	callbackAnswerLength = 200
+	defaultWorkersCount  = 2
+	defaultCacheSizeMb   = 200
+	defaultCacheDays     = 30
+)
+
+type conversionCallback func(srcFile string, destFormat string, options track.SimplifyOptions) (string, error)
 
 func NewTrackConverter(manager *mvc.Router, runtimeDir string) *TrackConverter {
 	c := &TrackConverter{}
@@ -33,23 +58,72 @@ func NewTrackConverter(manager *mvc.Router, runtimeDir string) *TrackConverter {
 	if c.ConverterId == 0 {
 		c.ConverterId = defaultConverterID
 	}
+	if c.Workers <= 0 {
+		c.Workers = defaultWorkersCount
+	}
+	if c.SplitGapMinutes <= 0 {
+		c.SplitGapMinutes = defaultSplitGap
+	}
+	if c.SplitDistanceKm <= 0 {
+		c.SplitDistanceKm = defaultSplitDistance
+	}
+	if c.CacheSizeMb <= 0 {
+		c.CacheSizeMb = defaultCacheSizeMb
+	}
+	if c.CacheDays <= 0 {
+		c.CacheDays = defaultCacheDays
+	}
+	c.Cache = NewResultCache(util.MakePath(c.RuntimeDir, "cache"), int64(c.CacheSizeMb)<<20, time.Duration(c.CacheDays)*24*time.Hour)
+	c.Queue = NewConversionQueue(util.MakePath(c.RuntimeDir, "conversion_queue.json"), c.Workers, c.processJob)
+	c.Queue.Start()
+	go c.sweepMergeSessions()
 	return c
 }
 
 type TrackConverter struct {
-	Manager     *mvc.Router `json:"-"`
-	Id          int     `json:"-"`
-	RuntimeDir  string  `json:"runtime_dir"`
-	BinaryName  string  `json:"binary_name"`
-	ConverterId int     `json:"converter_id"`
+	Manager     *mvc.Router      `json:"-"`
+	Queue       *ConversionQueue `json:"-"`
+	Cache       *ResultCache     `json:"-"`
+	RuntimeDir  string           `json:"runtime_dir"`
+	BinaryName  string           `json:"binary_name"`
+	ConverterId int              `json:"converter_id"`
+	Workers     int              `json:"workers"`
+	// Split settings: timezone name for day boundaries, pause length and part length
+	SplitTimezone   string  `json:"split_timezone"`
+	SplitGapMinutes int     `json:"split_gap_minutes"`
+	SplitDistanceKm float64 `json:"split_distance_km"`
+	// Result cache bounds
+	CacheSizeMb int `json:"cache_size_mb"`
+	CacheDays   int `json:"cache_days"`
+
+	mergeLock     sync.Mutex
+	mergeSessions map[int]*MergeSession
+}
+
+func (t *TrackConverter) GetNamespace() string {
+	return trackConverterNamespace
 }
 
-func (t *TrackConverter) SetId(id int) {
-	t.Id = id
+func (t *TrackConverter) CallbackVersion() int {
+	return trackConverterCallbackVersion
 }
 
 func (t *TrackConverter) Init(manager *mvc.Router) {
 	t.Manager = manager
+	t.mergeSessions = make(map[int]*MergeSession)
+
+	manager.RegisterCommand(&mvc.Command{
+		Name:        mergeCommand,
+		Description: "Объединить несколько треков в один",
+		Handler: func(message *tgbotapi.Message, args mvc.CommandArgs) {
+			t.StartMerge(message)
+		},
+	})
+	manager.RegisterCommand(&mvc.Command{
+		Name:        profileCommand,
+		Description: "Профиль высот трека, отправьте в ответ на файл",
+		Handler:     t.HandleProfileCommand,
+	})
 }
 
 func (t *TrackConverter) GetName() string {
@@ -57,7 +131,15 @@ func (t *TrackConverter) GetName() string {
 }
 
 func (t *TrackConverter) PrepareData(d ...string) string {
-	return fmt.Sprintf("%d|%s", t.Id, strings.Join(d, "|"))
+	return t.Manager.CallbackData(t.GetNamespace(), t.CallbackVersion(), d...)
+}
+
+// UpdateFilter accepts documents with tracks or archives of them.
+func (t *TrackConverter) UpdateFilter() mvc.Filter {
+	return mvc.Filter{
+		Kinds:      mvc.KindDocument | mvc.KindCallback,
+		Extensions: append(t.GetSortedFormats(), archiveExt),
+	}
 }
 
 func (t *TrackConverter) HandleMessage(update tgbotapi.Update) {
@@ -65,50 +147,89 @@ func (t *TrackConverter) HandleMessage(update tgbotapi.Update) {
 	if message.Document != nil {
 		doc := message.Document
 
-		if !util.FileExists(t.GetGpsbabelPath()) {
-			log.Printf("Gpsbabel application is not found!")
+		if t.IsKnownFormat(path.Ext(doc.FileName)) && t.AddMergeFile(message) {
 			return
 		}
 
-		if !t.IsKnownFormat(path.Ext(doc.FileName)) {
+		if t.ConverterId != internalConverterID && !util.FileExists(t.GetGpsbabelPath()) {
+			log.Printf("Gpsbabel application is not found!")
 			return
 		}
 
-		var buttons []tgbotapi.InlineKeyboardButton
-
-		for _, format := range t.GetKnownFormatsMap() {
-			if strings.HasSuffix(doc.FileName, format) {
-				continue
-			}
-			var data string = t.PrepareData(doc.FileID, format)
-			buttons = append(buttons, tgbotapi.NewInlineKeyboardButtonData("Сделать "+format, data))
+		if !t.IsKnownFormat(path.Ext(doc.FileName)) && !IsArchive(doc.FileName) {
+			return
 		}
 
-		markup := tgbotapi.NewInlineKeyboardMarkup(
-			tgbotapi.NewInlineKeyboardRow(
-				buttons...,
-			),
-		)
+		markup := t.FormatsKeyboard(doc)
 		msg := tgbotapi.NewMessage(message.Chat.ID, "")
 		msg.ReplyToMessageID = message.MessageID
 		msg.ParseMode = "HTML"
 		msg.ReplyMarkup = markup
-		msg.Text = "Могу сконвертировать этот файл в один из следующих форматов:"
-		t.Manager.Results <- msg
+		msg.Text = formatsKeyboardText
+		t.Manager.Send(msg)
 	}
 }
 
+// FormatsKeyboard offers target formats and other actions for the document.
+func (t *TrackConverter) FormatsKeyboard(doc *tgbotapi.Document) tgbotapi.InlineKeyboardMarkup {
+	var rows [][]tgbotapi.InlineKeyboardButton
+	var buttons []tgbotapi.InlineKeyboardButton
+
+	for _, format := range t.GetSortedFormats() {
+		if strings.HasSuffix(doc.FileName, format) {
+			continue
+		}
+		var data string = t.PrepareData(doc.FileID, format)
+		buttons = append(buttons, tgbotapi.NewInlineKeyboardButtonData("Сделать "+format, data))
+		if len(buttons) == buttonsPerRow {
+			rows = append(rows, tgbotapi.NewInlineKeyboardRow(buttons...))
+			buttons = nil
+		}
+	}
+	if len(buttons) > 0 {
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(buttons...))
+	}
+	if IsArchive(doc.FileName) {
+		return tgbotapi.NewInlineKeyboardMarkup(rows...)
+	}
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("Статистика", t.PrepareData(doc.FileID, statisticsAction)),
+		tgbotapi.NewInlineKeyboardButtonData("Карта", t.PrepareData(doc.FileID, mapAction)),
+		tgbotapi.NewInlineKeyboardButtonData("Профиль высот", t.PrepareData(doc.FileID, profileAction)),
+	))
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("Разделить", t.PrepareData(doc.FileID, splitAction)),
+	))
+
+	return tgbotapi.NewInlineKeyboardMarkup(rows...)
+}
+
 func (t *TrackConverter) GetKnownFormatsMap() map[string]string {
 	return map[string]string{
-		".kml": "kml",
-		".kmz": "kmz",
-		".plt": "ozi",
-		".gpx": "gpx",
+		".kml":     "kml",
+		".kmz":     "kmz",
+		".plt":     "ozi",
+		".wpt":     "ozi",
+		".rte":     "ozi",
+		".gpx":     "gpx",
+		".geojson": "geojson",
+	}
+}
+
+// GetSortedFormats returns known extensions in a stable order, so the buttons don't jump around.
+func (t *TrackConverter) GetSortedFormats() []string {
+	var formats []string
+	for ext := range t.GetKnownFormatsMap() {
+		formats = append(formats, ext)
 	}
+	sort.Strings(formats)
+	return formats
 }
 
+// IsKnownFormat tells if the extension is known, regardless of case.
 func (t *TrackConverter) IsKnownFormat(format string) bool {
-	for ext, _ := range t.GetKnownFormatsMap() {
+	format = strings.ToLower(format)
+	for ext := range t.GetKnownFormatsMap() {
 		if format == ext {
 			return true
 		}
@@ -117,74 +238,354 @@ func (t *TrackConverter) IsKnownFormat(format string) bool {
 }
 
 func (t *TrackConverter) HandleCallback(update tgbotapi.Update) {
-	if update.CallbackQuery == nil {
+	callback := update.CallbackQuery
+	if callback == nil {
 		log.Println("ERR Callback data empty")
 		return
 	}
-	parts := strings.Split(update.CallbackQuery.Data, "|")
+	answer := ""
+	defer func() {
+		t.answerCallback(callback, answer)
+	}()
+
+	parts := strings.Split(callback.Data, "|")
+	if len(parts) > 1 && parts[1] == mergeAction {
+		answer = t.HandleMergeCallback(callback, parts[2:])
+		return
+	}
+	if len(parts) < 3 || callback.Message == nil || callback.Message.ReplyToMessage == nil || callback.Message.ReplyToMessage.Document == nil {
+		log.Printf("Malformed callback: %s\n", callback.Data)
+		return
+	}
 	cmd, fileID, destFormat := parts[0], parts[1], parts[2]
-
 	log.Printf("%s, %s, %s", cmd, fileID, destFormat)
-	file, err := t.Manager.Bot.GetFile(tgbotapi.FileConfig{fileID})
+
+	source := callback.Message.ReplyToMessage
+	var err error
+	switch destFormat {
+	case statisticsAction, mapAction, profileAction:
+		t.enqueueAction(source, destFormat)
+		answer = actionQueuedText
+	case splitAction:
+		answer = t.HandleSplitCallback(callback, fileID, parts[3:])
+	case backAction:
+		t.editKeyboard(callback.Message, formatsKeyboardText, t.FormatsKeyboard(source.Document))
+	default:
+		if !t.IsKnownFormat(destFormat) {
+			err = NewConversionError(ErrUnsupportedFormat, "*"+destFormat, nil)
+			break
+		}
+		if len(parts) < 4 {
+			t.editKeyboard(callback.Message, "Как сконвертировать в "+destFormat+"?", t.SimplifyKeyboard(fileID, destFormat))
+			break
+		}
+		t.enqueue(source, fileID, destFormat, parts[3])
+		answer = "Файл добавлен в очередь"
+	}
+
 	if err != nil {
-		log.Println("GET FILE ERR: " + err.Error())
+		answer = t.reportError(source, err, source.Document.FileName)
+	}
+}
+
+func (t *TrackConverter) enqueue(source *tgbotapi.Message, fileID, destFormat, simplify string) {
+	job := &ConversionJob{
+		ChatId:           source.Chat.ID,
+		ReplyToMessageId: source.MessageID,
+		FileId:           fileID,
+		FileName:         source.Document.FileName,
+		DestFormat:       destFormat,
+		Simplify:         simplify,
+	}
+	// The status message is sent first, a worker may take the job as soon as it is pushed
+	msg := tgbotapi.NewMessage(job.ChatId, fmt.Sprintf("В очереди на конвертацию, позиция %d", t.Queue.NextPosition(job.ChatId)))
+	msg.ReplyToMessageID = job.ReplyToMessageId
+	if sent, err := t.Manager.SendSync(msg); err != nil {
+		log.Printf("Failed to send queue status: %s\n", err)
+	} else {
+		job.StatusMessageId = sent.MessageID
+	}
+	t.Queue.Push(job)
+}
+
+// enqueueAction queues work on the document other than conversion. It is
+// done by the workers as well, so callbacks are answered right away.
+func (t *TrackConverter) enqueueAction(source *tgbotapi.Message, action string, args ...string) {
+	t.Queue.Push(&ConversionJob{
+		ChatId:           source.Chat.ID,
+		ReplyToMessageId: source.MessageID,
+		FileId:           source.Document.FileID,
+		FileName:         source.Document.FileName,
+		Action:           action,
+		Args:             args,
+	})
+}
+
+// answerCallback stops the button spinner, showing text as a notification if it is set.
+func (t *TrackConverter) answerCallback(callback *tgbotapi.CallbackQuery, text string) {
+	if r := []rune(text); len(r) > callbackAnswerLength {
+		text = string(r[:callbackAnswerLength-3]) + "..."
+	}
+	t.Manager.AnswerCallback(tgbotapi.NewCallback(callback.ID, text))
+}
+
+// reportError logs the error and explains it in reply to the source message.
+// The explanation is returned, so it can be shown somewhere else as well.
+func (t *TrackConverter) reportError(source *tgbotapi.Message, err error, fileName string) string {
+	convErr := AsConversionError(err, fileName)
+	log.Printf("CONVERSION ERR: %s\n", convErr)
+
+	msg := tgbotapi.NewMessage(source.Chat.ID, convErr.UserMessage())
+	msg.ReplyToMessageID = source.MessageID
+	t.Manager.Send(msg)
+	return convErr.UserMessage()
+}
+
+func (t *TrackConverter) editKeyboard(message *tgbotapi.Message, text string, markup tgbotapi.InlineKeyboardMarkup) {
+	edit := tgbotapi.NewEditMessageText(message.Chat.ID, message.MessageID, text)
+	edit.ReplyMarkup = &markup
+	t.Manager.SendWithCallback(edit, logSendError("edit keyboard"))
+}
+
+func (t *TrackConverter) editText(message *tgbotapi.Message, text string) {
+	edit := tgbotapi.NewEditMessageText(message.Chat.ID, message.MessageID, text)
+	t.Manager.SendWithCallback(edit, logSendError("edit message"))
+}
+
+// logSendError returns a result callback which logs failed requests.
+func logSendError(action string) mvc.ResultCallback {
+	return func(message tgbotapi.Message, err error) {
+		if err != nil {
+			log.Printf("Failed to %s: %s\n", action, err)
+		}
+	}
+}
+
+func (t *TrackConverter) setJobStatus(job *ConversionJob, status string, text string) {
+	t.Queue.SetStatus(job, status)
+	t.editJobMessage(job, text)
+}
+
+func (t *TrackConverter) editJobMessage(job *ConversionJob, text string) {
+	if job.StatusMessageId == 0 {
 		return
 	}
+	edit := tgbotapi.NewEditMessageText(job.ChatId, job.StatusMessageId, text)
+	t.Manager.SendWithCallback(edit, logSendError("edit job status message"))
+}
+
+func (t *TrackConverter) failJob(job *ConversionJob, err error) {
+	t.editJobMessage(job, "Ошибка конвертации")
+	source := &tgbotapi.Message{MessageID: job.ReplyToMessageId, Chat: &tgbotapi.Chat{ID: job.ChatId}}
+	t.reportError(source, err, job.FileName)
+}
 
-	srcFileName := t.RuntimeDir + "/" + update.CallbackQuery.Message.ReplyToMessage.Document.FileName
-	util.DownloadFile(file.Link(t.Manager.Bot.Token), srcFileName)
+func (t *TrackConverter) resultCacheKey(job *ConversionJob) string {
+	return ResultCacheKey(job.FileId, job.DestFormat, job.Simplify, strconv.Itoa(t.ConverterId))
+}
+
+// sendResult uploads the conversion result and remembers it in the cache.
+func (t *TrackConverter) sendResult(job *ConversionJob, fileName string, content []byte) error {
+	doc := tgbotapi.NewDocumentUpload(job.ChatId, tgbotapi.FileBytes{Name: fileName, Bytes: content})
+	doc.ReplyToMessageID = job.ReplyToMessageId
+	sent, err := t.Manager.SendSync(doc)
 	if err != nil {
-		log.Printf("Error downloading file: %s\n", err)
-		return
+		return NewConversionError(ErrUploadFailed, job.FileName, err)
 	}
+	if sent.Document != nil {
+		t.Cache.Put(t.resultCacheKey(job), fileName, content, sent.Document.FileID)
+	}
+	return nil
+}
 
-	var newFileName string
-	switch t.ConverterId {
-	case 1:
-		{
-			newFileName, err = t.convert(srcFileName, destFormat, t.ConvertInternalFile)
-			break
+// sendCachedResult sends the result of the same conversion made earlier.
+// It returns false when there is no such result and the job has to be done.
+func (t *TrackConverter) sendCachedResult(job *ConversionJob) bool {
+	key := t.resultCacheKey(job)
+	entry, ok := t.Cache.Get(key)
+	if !ok {
+		return false
+	}
+
+	t.setJobStatus(job, JobStatusUploading, "Отправляю результат...")
+	doc := tgbotapi.NewDocumentShare(job.ChatId, entry.ResultFileId)
+	doc.ReplyToMessageID = job.ReplyToMessageId
+	if _, err := t.Manager.SendSync(doc); err != nil {
+		log.Printf("Failed to send cached file %s: %s\n", entry.ResultFileId, err)
+		content, err := t.Cache.Content(entry)
+		if err != nil {
+			t.Cache.Forget(key)
+			return false
 		}
-	case 2:
-	default:
-		{
-			newFileName, err = t.convert(srcFileName, destFormat, t.ConvertUsingGpsBabel)
-			break
+		// The new upload replaces the entry in the cache
+		if err = t.sendResult(job, entry.FileName, content); err != nil {
+			t.Cache.Forget(key)
+			t.failJob(job, err)
+			return true
 		}
 	}
+	t.editJobMessage(job, "Готово!")
+	return true
+}
 
+func (t *TrackConverter) processJob(job *ConversionJob) {
+	if job.Action != "" {
+		t.processActionJob(job)
+		return
+	}
+	if t.sendCachedResult(job) {
+		return
+	}
+
+	// Every job works in its own directory, so files with equal names don't collide
+	workDir, err := ioutil.TempDir(t.RuntimeDir, "job")
 	if err != nil {
-		log.Printf("Failed to convert file: %s\n", err)
+		log.Printf("Failed to create job dir: %s\n", err)
+		t.failJob(job, err)
 		return
 	}
+	defer os.RemoveAll(workDir)
 
+	t.setJobStatus(job, JobStatusDownloading, "Скачиваю файл...")
+	srcFileName, err := t.downloadFile(workDir, job.FileId, job.FileName)
+	if err != nil {
+		t.failJob(job, err)
+		return
+	}
+
+	t.setJobStatus(job, JobStatusConverting, fmt.Sprintf("Конвертирую в %s (%s)...", job.DestFormat, simplifyTitle(job.Simplify)))
+	options := ParseSimplifyOption(job.Simplify)
+	if IsArchive(job.FileName) {
+		t.processArchiveJob(job, srcFileName, options)
+		return
+	}
+	newFileName, err := t.convert(srcFileName, job.DestFormat, options, t.getConverter())
+	if err != nil {
+		t.failJob(job, err)
+		return
+	}
+
+	content, err := ioutil.ReadFile(newFileName)
+	if err != nil {
+		t.failJob(job, err)
+		return
+	}
+
+	t.setJobStatus(job, JobStatusUploading, "Отправляю результат...")
 	log.Printf("Sending file: %s", newFileName)
-	doc := tgbotapi.NewDocumentUpload(update.CallbackQuery.Message.ReplyToMessage.Chat.ID, newFileName)
-	doc.ReplyToMessageID = update.CallbackQuery.Message.ReplyToMessage.MessageID
-	t.Manager.Bot.Send(doc)
+	if err = t.sendResult(job, filepath.Base(newFileName), content); err != nil {
+		t.failJob(job, err)
+		return
+	}
+	t.editJobMessage(job, "Готово!")
+}
+
+// processActionJob does the queued work on the document other than conversion.
+func (t *TrackConverter) processActionJob(job *ConversionJob) {
+	source := &tgbotapi.Message{
+		MessageID: job.ReplyToMessageId,
+		Chat:      &tgbotapi.Chat{ID: job.ChatId},
+		Document:  &tgbotapi.Document{FileID: job.FileId, FileName: job.FileName},
+	}
 
-	// Here we can make some tracks cache
-	os.Remove(newFileName)
-	os.Remove(srcFileName)
+	var err error
+	switch job.Action {
+	case statisticsAction:
+		err = t.SendStatistics(source)
+	case mapAction:
+		err = t.SendMapPreview(source)
+	case profileAction:
+		err = t.SendProfile(source)
+	case splitAction:
+		if len(job.Args) < 2 {
+			err = fmt.Errorf("Split job %d has no mode", job.Id)
+			break
+		}
+		err = t.Split(source, job.Args[0], job.Args[1] == splitAsZip)
+	case mergeAction:
+		err = t.processMergeJob(job)
+	default:
+		err = fmt.Errorf("Unknown job action %s", job.Action)
+	}
+	if err != nil {
+		t.failJob(job, err)
+	}
+}
+
+// downloadFile saves the file into dir and returns its path.
+func (t *TrackConverter) downloadFile(dir, fileID, fileName string) (string, error) {
+	file, err := t.Manager.Bot.GetFile(tgbotapi.FileConfig{FileID: fileID})
+	if err != nil {
+		log.Println("GET FILE ERR: " + err.Error())
+		return "", NewConversionError(ErrDownloadFailed, fileName, err)
+	}
+
+	destFileName := util.MakePath(dir, filepath.Base(fileName))
+	_, err = util.DownloadFile(file.Link(t.Manager.Bot.Token), destFileName)
+	if err != nil {
+		log.Printf("Error downloading file: %s\n", err)
+		return "", NewConversionError(ErrDownloadFailed, fileName, err)
+	}
+	return destFileName, nil
+}
+
+// readDocumentTrack downloads the document and parses it with the built-in track reader.
+func (t *TrackConverter) readDocumentTrack(doc *tgbotapi.Document) (track.FormatReaderWriter, error) {
+	workDir, err := ioutil.TempDir(t.RuntimeDir, "read")
+	if err != nil {
+		log.Printf("Failed to create download dir: %s\n", err)
+		return nil, err
+	}
+	defer os.RemoveAll(workDir)
+
+	fileName, err := t.downloadFile(workDir, doc.FileID, doc.FileName)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := track.ReadFile(fileName)
+	if err != nil {
+		log.Printf("Failed to read track: %s\n", err)
+		return nil, AsConversionError(err, doc.FileName)
+	}
+	return data, nil
 }
 
-func (t *TrackConverter) convert(srcFile, destFormat string, converter conversionCallback) (string, error) {
-	return converter(srcFile, destFormat)
+func (t *TrackConverter) getConverter() conversionCallback {
+	if t.ConverterId == internalConverterID {
+		return t.ConvertInternalFile
+	}
+	return t.ConvertUsingGpsBabel
+}
+
+func (t *TrackConverter) convert(srcFile, destFormat string, options track.SimplifyOptions, converter conversionCallback) (string, error) {
+	return converter(srcFile, destFormat, options)
 }
 
 func (t *TrackConverter) TrackToArguments(srcFile, dstFormat string) (string, string, string, string) {
 	formatMap := t.GetKnownFormatsMap()
 
-	srcFormat := strings.ToLower(formatMap[path.Ext(srcFile)])
-	fileName := strings.TrimSuffix(path.Base(srcFile), "."+srcFormat)
-	destFileName := fmt.Sprintf("%s%s%s", t.RuntimeDir, string(os.PathSeparator), fileName+dstFormat)
-	return srcFormat, srcFile, formatMap[dstFormat], destFileName
+	// Extensions are matched regardless of case, like IsKnownFormat does
+	srcFormat := formatMap[strings.ToLower(path.Ext(srcFile))]
+	fileName := strings.TrimSuffix(path.Base(srcFile), path.Ext(srcFile))
+	// The result is written next to the source, in the directory of the job
+	destFileName := util.MakePath(filepath.Dir(srcFile), fileName+dstFormat)
+	return srcFormat, srcFile, formatMap[strings.ToLower(dstFormat)], destFileName
 }
 
-func (t *TrackConverter) ConvertUsingGpsBabel(srcFile, dstFormat string) (string, error) {
+func (t *TrackConverter) ConvertUsingGpsBabel(srcFile, dstFormat string, options track.SimplifyOptions) (string, error) {
 	srcFormat, srcFile, dstFormat, dstFileName := t.TrackToArguments(srcFile, dstFormat)
 
-	cmd := exec.Command(t.GetGpsbabelPath(), "-i", srcFormat, "-f", srcFile, "-o", dstFormat, "-F", dstFileName)
+	args := []string{"-i", srcFormat, "-f", srcFile}
+	if options.Tolerance > 0 {
+		args = append(args, "-x", fmt.Sprintf("simplify,crosstrack,error=%gk", options.Tolerance/1000))
+	}
+	if options.MaxPoints > 0 {
+		args = append(args, "-x", fmt.Sprintf("simplify,count=%d", options.MaxPoints))
+	}
+	args = append(args, "-o", dstFormat, "-F", dstFileName)
+
+	cmd := exec.Command(t.GetGpsbabelPath(), args...)
 	var stdout bytes.Buffer
 	var stderr bytes.Buffer
 	cmd.Stdout = &stdout
@@ -193,7 +594,14 @@ func (t *TrackConverter) ConvertUsingGpsBabel(srcFile, dstFormat string) (string
 	cmd.Wait()
 	if err != nil {
 		log.Printf("CONVERT ERR: %s\n%s\n%s\n", err.Error(), stdout.String(), stderr.String())
-		return "", err
+		convErr := NewConversionError(ErrConverterCrashed, filepath.Base(srcFile), err)
+		convErr.Stderr = stderrExcerpt(stderr.String())
+		return "", convErr
+	}
+
+	if info, err := os.Stat(dstFileName); err != nil || info.Size() == 0 {
+		os.Remove(dstFileName)
+		return "", NewConversionError(ErrOutputEmpty, filepath.Base(srcFile), err)
 	}
 
 	log.Printf("Successfully converted. Output is:\n%s", stdout.String())
@@ -204,38 +612,18 @@ func (t *TrackConverter) GetGpsbabelPath() string {
 	return t.RuntimeDir + string(os.PathSeparator) + t.BinaryName
 }
 
-func (t *TrackConverter) ConvertInternalFile(srcFile, dstFormat string) (string, error) {
-	converters := map[string]gogpslib.FormatReaderWriter{
-		".plt": &gogpslib.PltFormat{},
-		".gpx": &gogpslib.GpxFormat{},
-	}
-
-	ext := filepath.Ext(srcFile)
+func (t *TrackConverter) ConvertInternalFile(srcFile, dstFormat string, options track.SimplifyOptions) (string, error) {
 	basename := filepath.Base(srcFile)
 	newName := strings.TrimSuffix(basename, filepath.Ext(basename)) + dstFormat
 
-	src := converters[ext]
-	src.Read(srcFile)
-
-	dst := converters[dstFormat]
-	dst.SetSegments(src.GetSegments())
-
-	w := writer.CreateStringWriter()
-	dst.WriteSegments(w)
-	w.Write()
-
 	abs, _ := filepath.Abs(srcFile)
 	destFileName := filepath.Dir(abs) + string(os.PathSeparator) + newName
 
-	d, err := os.Create(destFileName)
+	err := track.Convert(srcFile, destFileName, options)
 	if err != nil {
-		log.Printf("Error creating dest file name: %s", destFileName)
-		return "", fmt.Errorf("Error creating dest file name: %s", destFileName)
+		log.Printf("CONVERT ERR: %s\n", err)
+		return "", AsConversionError(err, basename)
 	}
-	defer d.Close()
-	defer os.Remove(srcFile)
-
-	d.Write([]byte(w.Content))
 
 	return destFileName, nil
 }
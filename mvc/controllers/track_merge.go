@@ -0,0 +1,235 @@
+package controllers
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/nolka/gooffroadmaster/track"
+	"gopkg.in/telegram-bot-api.v4"
+)
+
+const (
+	mergeCommand    = "merge"
+	mergeAction     = "merge"
+	mergeMaxFiles   = 20
+	mergeStart      = "start"
+	mergeCancel     = "cancel"
+	mergeBySegments = "seg"
+	mergeByTime     = "time"
+	// Sessions without new files for longer are dropped
+	mergeSessionTimeout = 30 * time.Minute
+	mergeSweepPeriod    = time.Minute
+)
+
+type mergeFile struct {
+	FileId   string `json:"file_id"`
+	FileName string `json:"file_name"`
+}
+
+// MergeSession collects track documents sent by a user after /merge.
+type MergeSession struct {
+	ChatId    int64
+	UserId    int
+	MessageId int
+	Files     []mergeFile
+	UpdatedAt time.Time
+}
+
+func (t *TrackConverter) getMergeSession(userId int) *MergeSession {
+	t.mergeLock.Lock()
+	defer t.mergeLock.Unlock()
+	return t.mergeSessions[userId]
+}
+
+func (t *TrackConverter) closeMergeSession(userId int) {
+	t.mergeLock.Lock()
+	defer t.mergeLock.Unlock()
+	delete(t.mergeSessions, userId)
+}
+
+// sweepMergeSessions drops abandoned merge sessions.
+func (t *TrackConverter) sweepMergeSessions() {
+	for now := range time.Tick(mergeSweepPeriod) {
+		var expired []*MergeSession
+		t.mergeLock.Lock()
+		for userId, session := range t.mergeSessions {
+			if now.Sub(session.UpdatedAt) > mergeSessionTimeout {
+				delete(t.mergeSessions, userId)
+				expired = append(expired, session)
+			}
+		}
+		t.mergeLock.Unlock()
+
+		for _, session := range expired {
+			edit := tgbotapi.NewEditMessageText(session.ChatId, session.MessageId, "Время объединения истекло, начните заново с /"+mergeCommand)
+			t.Manager.SendWithCallback(edit, logSendError("expire merge session"))
+		}
+	}
+}
+
+func (t *TrackConverter) StartMerge(message *tgbotapi.Message) {
+	session := &MergeSession{ChatId: message.Chat.ID, UserId: message.From.ID, UpdatedAt: time.Now()}
+
+	msg := tgbotapi.NewMessage(message.Chat.ID, "Присылайте файлы треков, затем нажмите «Объединить»")
+	msg.ReplyToMessageID = message.MessageID
+	msg.ReplyMarkup = t.mergeKeyboard()
+	sent, err := t.Manager.SendSync(msg)
+	if err != nil {
+		log.Printf("Failed to start merge: %s\n", err)
+		return
+	}
+	session.MessageId = sent.MessageID
+
+	t.mergeLock.Lock()
+	t.mergeSessions[session.UserId] = session
+	t.mergeLock.Unlock()
+}
+
+func (t *TrackConverter) mergeKeyboard() tgbotapi.InlineKeyboardMarkup {
+	return tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("Объединить", t.PrepareData(mergeAction, mergeStart)),
+			tgbotapi.NewInlineKeyboardButtonData("Отмена", t.PrepareData(mergeAction, mergeCancel)),
+		),
+	)
+}
+
+// AddMergeFile adds the document to the user's merge session. It returns false
+// when the user has no session in this chat, so the document is handled as usual.
+func (t *TrackConverter) AddMergeFile(message *tgbotapi.Message) bool {
+	t.mergeLock.Lock()
+	session, ok := t.mergeSessions[message.From.ID]
+	if !ok || session.ChatId != message.Chat.ID {
+		t.mergeLock.Unlock()
+		return false
+	}
+	full := len(session.Files) >= mergeMaxFiles
+	if !full {
+		session.Files = append(session.Files, mergeFile{FileId: message.Document.FileID, FileName: message.Document.FileName})
+	}
+	session.UpdatedAt = time.Now()
+	count := len(session.Files)
+	t.mergeLock.Unlock()
+
+	text := fmt.Sprintf("Файлов для объединения: %d", count)
+	if full {
+		text = fmt.Sprintf("Можно объединить не больше %d файлов", mergeMaxFiles)
+	}
+	edit := tgbotapi.NewEditMessageText(session.ChatId, session.MessageId, text)
+	markup := t.mergeKeyboard()
+	edit.ReplyMarkup = &markup
+	t.Manager.SendWithCallback(edit, logSendError("update merge session"))
+	return true
+}
+
+// HandleMergeCallback handles "merge|<step>[|<format>|<mode>]" callback data.
+// It returns the callback answer.
+func (t *TrackConverter) HandleMergeCallback(callback *tgbotapi.CallbackQuery, args []string) string {
+	session := t.getMergeSession(callback.From.ID)
+	if session == nil || len(args) == 0 {
+		return ""
+	}
+
+	switch args[0] {
+	case mergeCancel:
+		t.closeMergeSession(session.UserId)
+		t.editText(callback.Message, "Объединение отменено")
+	case mergeStart:
+		t.mergeLock.Lock()
+		count := len(session.Files)
+		t.mergeLock.Unlock()
+		if count < 2 {
+			t.editKeyboard(callback.Message, "Для объединения нужно хотя бы два файла", t.mergeKeyboard())
+			return ""
+		}
+		if len(args) < 3 {
+			t.editKeyboard(callback.Message, "Выберите формат и способ объединения", t.mergeFormatsKeyboard())
+			return ""
+		}
+		t.closeMergeSession(session.UserId)
+		t.editText(callback.Message, "Файлы в очереди на объединение")
+		// Files are downloaded by the workers, the session is closed and not changed any more
+		t.Queue.Push(&ConversionJob{
+			ChatId:           session.ChatId,
+			ReplyToMessageId: session.MessageId,
+			FileName:         "merged" + args[1],
+			Action:           mergeAction,
+			Args:             []string{args[1], args[2]},
+			Files:            session.Files,
+		})
+		return actionQueuedText
+	}
+	return ""
+}
+
+// processMergeJob merges the documents of the job, queued by HandleMergeCallback.
+func (t *TrackConverter) processMergeJob(job *ConversionJob) error {
+	if len(job.Args) < 2 {
+		return fmt.Errorf("Merge job %d has no format", job.Id)
+	}
+	session := &MergeSession{ChatId: job.ChatId, MessageId: job.ReplyToMessageId, Files: job.Files}
+	t.merge(session, job.Args[0], job.Args[1] == mergeByTime)
+	return nil
+}
+
+func (t *TrackConverter) mergeFormatsKeyboard() tgbotapi.InlineKeyboardMarkup {
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for _, format := range t.GetSortedFormats() {
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(format+" сегментами", t.PrepareData(mergeAction, mergeStart, format, mergeBySegments)),
+			tgbotapi.NewInlineKeyboardButtonData(format+" по времени", t.PrepareData(mergeAction, mergeStart, format, mergeByTime)),
+		))
+	}
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("Отмена", t.PrepareData(mergeAction, mergeCancel)),
+	))
+	return tgbotapi.NewInlineKeyboardMarkup(rows...)
+}
+
+func (t *TrackConverter) merge(session *MergeSession, format string, byTime bool) {
+	var segments []track.Segment
+	var waypoints []track.Waypoint
+	for _, f := range session.Files {
+		data, err := t.readDocumentTrack(&tgbotapi.Document{FileID: f.FileId, FileName: f.FileName})
+		if err != nil {
+			t.replyMerge(session, AsConversionError(err, f.FileName).UserMessage())
+			return
+		}
+		segments = append(segments, data.GetSegments()...)
+		waypoints = append(waypoints, data.GetWaypoints()...)
+	}
+
+	if byTime {
+		segments = []track.Segment{track.Concatenate("Merged", track.SortByTime(segments))}
+	}
+
+	result, err := track.NewFormat(format)
+	if err != nil {
+		t.replyMerge(session, AsConversionError(err, "merged"+format).UserMessage())
+		return
+	}
+	result.SetSegments(segments)
+	result.SetWaypoints(waypoints)
+
+	b := &bytes.Buffer{}
+	if err = result.Write(b); err != nil {
+		log.Printf("Failed to write merged track: %s\n", err)
+		t.replyMerge(session, "Не удалось объединить файлы")
+		return
+	}
+
+	doc := tgbotapi.NewDocumentUpload(session.ChatId, tgbotapi.FileBytes{Name: "merged" + format, Bytes: b.Bytes()})
+	doc.ReplyToMessageID = session.MessageId
+	if _, err = t.Manager.SendSync(doc); err != nil {
+		log.Printf("Failed to send merged track: %s\n", err)
+		t.replyMerge(session, NewConversionError(ErrUploadFailed, "merged"+format, err).UserMessage())
+	}
+}
+
+func (t *TrackConverter) replyMerge(session *MergeSession, text string) {
+	msg := tgbotapi.NewMessage(session.ChatId, text)
+	msg.ReplyToMessageID = session.MessageId
+	t.Manager.Send(msg)
+}
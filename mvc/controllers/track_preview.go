@@ -0,0 +1,70 @@
+package controllers
+
+import (
+	"log"
+	"path"
+
+	"github.com/nolka/gooffroadmaster/mvc"
+	"github.com/nolka/gooffroadmaster/render"
+	"gopkg.in/telegram-bot-api.v4"
+)
+
+// SendMapPreview replies to the document message with a rendered picture of the track.
+func (t *TrackConverter) SendMapPreview(source *tgbotapi.Message) error {
+	data, err := t.readDocumentTrack(source.Document)
+	if err != nil {
+		return err
+	}
+
+	canvas, err := render.RenderMap(data.GetSegments(), render.DefaultMapOptions())
+	if err != nil {
+		log.Printf("Failed to render map: %s\n", err)
+		t.replyHTML(source, "В этом файле нет треков")
+		return nil
+	}
+
+	return t.sendCanvas(source, canvas, "map.png")
+}
+
+func (t *TrackConverter) sendCanvas(source *tgbotapi.Message, canvas *render.Canvas, name string) error {
+	b, err := canvas.EncodePNG()
+	if err != nil {
+		log.Printf("Failed to encode image: %s\n", err)
+		return err
+	}
+
+	photo := tgbotapi.NewPhotoUpload(source.Chat.ID, tgbotapi.FileBytes{Name: name, Bytes: b})
+	photo.ReplyToMessageID = source.MessageID
+	if _, err = t.Manager.SendSync(photo); err != nil {
+		log.Printf("Failed to send image: %s\n", err)
+		return NewConversionError(ErrUploadFailed, source.Document.FileName, err)
+	}
+	return nil
+}
+
+// HandleProfileCommand handles /profile sent as a reply to a track document.
+func (t *TrackConverter) HandleProfileCommand(message *tgbotapi.Message, args mvc.CommandArgs) {
+	source := message.ReplyToMessage
+	if source == nil || source.Document == nil || !t.IsKnownFormat(path.Ext(source.Document.FileName)) {
+		t.replyHTML(message, "Отправьте /profile в ответ на файл с треком")
+		return
+	}
+	t.enqueueAction(source, profileAction)
+}
+
+// SendProfile replies to the document message with an elevation profile chart.
+func (t *TrackConverter) SendProfile(source *tgbotapi.Message) error {
+	data, err := t.readDocumentTrack(source.Document)
+	if err != nil {
+		return err
+	}
+
+	canvas, err := render.RenderProfile(data.GetSegments(), render.DefaultProfileOptions())
+	if err != nil {
+		log.Printf("Failed to render profile: %s\n", err)
+		t.replyHTML(source, "В этом треке нет данных о высоте")
+		return nil
+	}
+
+	return t.sendCanvas(source, canvas, "profile.png")
+}
@@ -0,0 +1,62 @@
+package controllers
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/nolka/gooffroadmaster/track"
+	"gopkg.in/telegram-bot-api.v4"
+)
+
+// Simplification choices offered after the target format is picked. Codes go
+// into callback data: "-" keeps the track as is, "d<metres>" is Douglas-Peucker
+// tolerance and "n<count>" is the maximum number of points per segment.
+var simplifyChoices = []struct {
+	Code  string
+	Title string
+}{
+	{"-", "как есть"},
+	{"d5", "упростить 5 м"},
+	{"n500", "до 500 точек"},
+}
+
+func ParseSimplifyOption(code string) track.SimplifyOptions {
+	options := track.SimplifyOptions{}
+	if len(code) < 2 {
+		return options
+	}
+	value, err := strconv.Atoi(code[1:])
+	if err != nil || value <= 0 {
+		return options
+	}
+	switch code[0] {
+	case 'd':
+		options.Tolerance = float64(value)
+	case 'n':
+		options.MaxPoints = value
+	}
+	return options
+}
+
+func (t *TrackConverter) SimplifyKeyboard(fileID, format string) tgbotapi.InlineKeyboardMarkup {
+	var buttons []tgbotapi.InlineKeyboardButton
+	for _, choice := range simplifyChoices {
+		buttons = append(buttons, tgbotapi.NewInlineKeyboardButtonData(choice.Title, t.PrepareData(fileID, format, choice.Code)))
+	}
+	return tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(buttons...),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("« Назад", t.PrepareData(fileID, backAction)),
+		),
+	)
+}
+
+// simplifyTitle describes the chosen option for status messages.
+func simplifyTitle(code string) string {
+	for _, choice := range simplifyChoices {
+		if choice.Code == code {
+			return choice.Title
+		}
+	}
+	return strings.TrimSpace(code)
+}
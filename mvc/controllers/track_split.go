@@ -0,0 +1,166 @@
+package controllers
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"log"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/nolka/gooffroadmaster/track"
+	"gopkg.in/telegram-bot-api.v4"
+)
+
+const (
+	splitAction          = "split"
+	splitByDay           = "day"
+	splitByGap           = "gap"
+	splitByDistance      = "dist"
+	splitAsZip           = "zip"
+	splitAsFiles         = "files"
+	splitMaxDocuments    = 10
+	defaultSplitGap      = 30
+	defaultSplitDistance = 50
+)
+
+func (t *TrackConverter) splitLocation() *time.Location {
+	if t.SplitTimezone == "" {
+		return time.Local
+	}
+	loc, err := time.LoadLocation(t.SplitTimezone)
+	if err != nil {
+		log.Printf("Unknown split timezone %s: %s\n", t.SplitTimezone, err)
+		return time.Local
+	}
+	return loc
+}
+
+func (t *TrackConverter) SplitKeyboard(fileID string) tgbotapi.InlineKeyboardMarkup {
+	return tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("по дням", t.PrepareData(fileID, splitAction, splitByDay)),
+			tgbotapi.NewInlineKeyboardButtonData(fmt.Sprintf("по паузам > %d мин", t.SplitGapMinutes), t.PrepareData(fileID, splitAction, splitByGap)),
+			tgbotapi.NewInlineKeyboardButtonData(fmt.Sprintf("каждые %g км", t.SplitDistanceKm), t.PrepareData(fileID, splitAction, splitByDistance)),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("« Назад", t.PrepareData(fileID, backAction)),
+		),
+	)
+}
+
+func (t *TrackConverter) splitDeliveryKeyboard(fileID, mode string) tgbotapi.InlineKeyboardMarkup {
+	return tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("одним zip", t.PrepareData(fileID, splitAction, mode, splitAsZip)),
+			tgbotapi.NewInlineKeyboardButtonData("отдельными файлами", t.PrepareData(fileID, splitAction, mode, splitAsFiles)),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("« Назад", t.PrepareData(fileID, splitAction)),
+		),
+	)
+}
+
+// HandleSplitCallback handles "<fileID>|split[|<mode>|<delivery>]" callback data.
+// It returns the callback answer.
+func (t *TrackConverter) HandleSplitCallback(callback *tgbotapi.CallbackQuery, fileID string, args []string) string {
+	source := callback.Message.ReplyToMessage
+	switch len(args) {
+	case 0:
+		t.editKeyboard(callback.Message, "Как разделить трек?", t.SplitKeyboard(fileID))
+	case 1:
+		t.editKeyboard(callback.Message, "Как прислать части?", t.splitDeliveryKeyboard(fileID, args[0]))
+	default:
+		t.editKeyboard(callback.Message, formatsKeyboardText, t.FormatsKeyboard(source.Document))
+		t.enqueueAction(source, splitAction, args[0], args[1])
+		return actionQueuedText
+	}
+	return ""
+}
+
+// Split cuts the document track into parts and sends them in the source format.
+func (t *TrackConverter) Split(source *tgbotapi.Message, mode string, asZip bool) error {
+	data, err := t.readDocumentTrack(source.Document)
+	if err != nil {
+		return err
+	}
+
+	loc := t.splitLocation()
+	var cutter track.Cutter
+	switch mode {
+	case splitByDay:
+		cutter = track.ByDay(loc)
+	case splitByGap:
+		cutter = track.ByGap(time.Duration(t.SplitGapMinutes) * time.Minute)
+	case splitByDistance:
+		cutter = track.ByDistance(t.SplitDistanceKm * 1000)
+	default:
+		return fmt.Errorf("Unknown split mode %s", mode)
+	}
+
+	parts := track.Split(data.GetSegments(), cutter)
+	if len(parts) < 2 {
+		t.replyHTML(source, "Трек не получилось разделить на части")
+		return nil
+	}
+
+	ext := path.Ext(source.Document.FileName)
+	baseName := strings.TrimSuffix(source.Document.FileName, ext)
+	var files []tgbotapi.FileBytes
+	for i, part := range parts {
+		name := fmt.Sprintf("%s_%02d", baseName, i+1)
+		if start := part.StartTime(); mode == splitByDay && !start.IsZero() {
+			name = fmt.Sprintf("%s_%s", baseName, start.In(loc).Format("2006-01-02"))
+		}
+
+		format, err := track.NewFormat(ext)
+		if err != nil {
+			return err
+		}
+		format.SetSegments(part.Segments)
+		b := &bytes.Buffer{}
+		if err = format.Write(b); err != nil {
+			log.Printf("Failed to write track part: %s\n", err)
+			return err
+		}
+		files = append(files, tgbotapi.FileBytes{Name: name + ext, Bytes: b.Bytes()})
+	}
+
+	if asZip || len(files) > splitMaxDocuments {
+		archive, err := zipFiles(files)
+		if err != nil {
+			log.Printf("Failed to pack track parts: %s\n", err)
+			return err
+		}
+		files = []tgbotapi.FileBytes{{Name: baseName + "_parts.zip", Bytes: archive}}
+	}
+
+	for _, f := range files {
+		doc := tgbotapi.NewDocumentUpload(source.Chat.ID, f)
+		doc.ReplyToMessageID = source.MessageID
+		if _, err = t.Manager.SendSync(doc); err != nil {
+			log.Printf("Failed to send track part: %s\n", err)
+			return NewConversionError(ErrUploadFailed, source.Document.FileName, err)
+		}
+	}
+	return nil
+}
+
+func zipFiles(files []tgbotapi.FileBytes) ([]byte, error) {
+	b := &bytes.Buffer{}
+	archive := zip.NewWriter(b)
+	for _, f := range files {
+		w, err := archive.Create(f.Name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err = w.Write(f.Bytes); err != nil {
+			return nil, err
+		}
+	}
+	if err := archive.Close(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
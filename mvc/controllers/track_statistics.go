@@ -0,0 +1,82 @@
+package controllers
+
+import (
+	"fmt"
+	"html"
+	"strings"
+	"time"
+
+	"github.com/nolka/gooffroadmaster/track"
+	"gopkg.in/telegram-bot-api.v4"
+)
+
+const maxMessageLength = 4000
+
+// SendStatistics replies to the document message with statistics of every track segment.
+func (t *TrackConverter) SendStatistics(source *tgbotapi.Message) error {
+	data, err := t.readDocumentTrack(source.Document)
+	if err != nil {
+		return err
+	}
+
+	segments := data.GetSegments()
+	if len(segments) == 0 {
+		t.replyHTML(source, "В этом файле нет треков")
+		return nil
+	}
+
+	var text string
+	for i, segment := range segments {
+		block := FormatSegmentStats(i+1, segment.Name, track.CalculateStats(segment))
+		if len(text)+len(block) > maxMessageLength {
+			t.replyHTML(source, text)
+			text = ""
+		}
+		text += block
+	}
+	t.replyHTML(source, text)
+	return nil
+}
+
+func (t *TrackConverter) replyHTML(source *tgbotapi.Message, text string) {
+	msg := tgbotapi.NewMessage(source.Chat.ID, text)
+	msg.ReplyToMessageID = source.MessageID
+	msg.ParseMode = "HTML"
+	t.Manager.Send(msg)
+}
+
+func FormatSegmentStats(num int, name string, stats track.SegmentStats) string {
+	b := &strings.Builder{}
+	fmt.Fprintf(b, "<b>Сегмент %d</b>", num)
+	if name != "" {
+		fmt.Fprintf(b, " (%s)", html.EscapeString(name))
+	}
+	b.WriteString("\n")
+	fmt.Fprintf(b, "Точек: %d\n", stats.Points)
+	fmt.Fprintf(b, "Расстояние: %.2f км\n", stats.Distance/1000)
+
+	if stats.HasTime {
+		fmt.Fprintf(b, "Время в движении: %s\n", formatDuration(stats.MovingTime))
+		fmt.Fprintf(b, "Время стоянок: %s\n", formatDuration(stats.StoppedTime))
+		fmt.Fprintf(b, "Средняя скорость: %.1f км/ч\n", stats.AvgSpeed*3.6)
+		fmt.Fprintf(b, "Максимальная скорость: %.1f км/ч\n", stats.MaxSpeed*3.6)
+	} else {
+		b.WriteString("Время: нет данных\n")
+	}
+
+	if stats.HasEle {
+		fmt.Fprintf(b, "Набор высоты: %.0f м, сброс: %.0f м\n", stats.ElevationGain, stats.ElevationLoss)
+		fmt.Fprintf(b, "Высота: от %.0f до %.0f м\n", stats.MinEle, stats.MaxEle)
+	} else {
+		b.WriteString("Высота: нет данных\n")
+	}
+
+	fmt.Fprintf(b, "Границы: %.6f, %.6f — %.6f, %.6f\n\n",
+		stats.Bounds.MinLat, stats.Bounds.MinLon, stats.Bounds.MaxLat, stats.Bounds.MaxLon)
+	return b.String()
+}
+
+func formatDuration(d time.Duration) string {
+	d = d.Round(time.Minute)
+	return fmt.Sprintf("%dч %02dм", int(d.Hours()), int(d.Minutes())%60)
+}
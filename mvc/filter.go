@@ -0,0 +1,115 @@
+package mvc
+
+import (
+	"path"
+	"strings"
+
+	"gopkg.in/telegram-bot-api.v4"
+)
+
+// UpdateKind is a set of update kinds.
+type UpdateKind int
+
+// my_chat_member updates are not listed: the API library drops them.
+const (
+	KindMessage UpdateKind = 1 << iota
+	KindEditedMessage
+	KindChannelPost
+	KindEditedChannelPost
+	KindInlineQuery
+	KindChosenInlineResult
+	KindCallback
+	// New message with a document, it is a KindMessage as well
+	KindDocument
+)
+
+// DispatchPolicy tells whether an update goes to every accepting controller or to the first one.
+type DispatchPolicy int
+
+const (
+	Broadcast DispatchPolicy = iota
+	FirstMatch
+)
+
+// KindOf returns kinds of the update.
+func KindOf(update tgbotapi.Update) UpdateKind {
+	switch {
+	case update.Message != nil:
+		if update.Message.Document != nil {
+			return KindMessage | KindDocument
+		}
+		return KindMessage
+	case update.EditedMessage != nil:
+		return KindEditedMessage
+	case update.ChannelPost != nil:
+		return KindChannelPost
+	case update.EditedChannelPost != nil:
+		return KindEditedChannelPost
+	case update.InlineQuery != nil:
+		return KindInlineQuery
+	case update.ChosenInlineResult != nil:
+		return KindChosenInlineResult
+	case update.CallbackQuery != nil:
+		return KindCallback
+	}
+	return 0
+}
+
+// Filter describes updates a controller accepts.
+type Filter struct {
+	Kinds UpdateKind
+	// Chat types like "private" or "group", any if empty
+	ChatTypes []string
+	// Documents accepted through KindDocument, any if both are empty
+	Extensions []string
+	MimeTypes  []string
+}
+
+// Filterable is implemented by controllers which choose their updates.
+// Other controllers get new messages and their callbacks.
+type Filterable interface {
+	UpdateFilter() Filter
+}
+
+var defaultFilter = Filter{Kinds: KindMessage | KindCallback}
+
+func filterOf(c BotMessageComponentInterface) Filter {
+	if f, ok := c.(Filterable); ok {
+		return f.UpdateFilter()
+	}
+	return defaultFilter
+}
+
+func (f Filter) Accepts(update tgbotapi.Update) bool {
+	kind := KindOf(update)
+	if f.Kinds&kind == 0 {
+		return false
+	}
+	if len(f.ChatTypes) > 0 {
+		chat := UpdateChat(update)
+		if chat == nil || !containsFold(f.ChatTypes, chat.Type) {
+			return false
+		}
+	}
+	// Accepted only as a document, so it has to be a proper one
+	if f.Kinds&kind == KindDocument {
+		return f.acceptsDocument(update.Message.Document)
+	}
+	return true
+}
+
+func (f Filter) acceptsDocument(doc *tgbotapi.Document) bool {
+	if len(f.Extensions) == 0 && len(f.MimeTypes) == 0 {
+		return true
+	}
+	return containsFold(f.Extensions, path.Ext(doc.FileName)) || containsFold(f.MimeTypes, doc.MimeType)
+}
+
+func containsFold(list []string, s string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,58 @@
+package mvc
+
+import (
+	"testing"
+
+	"gopkg.in/telegram-bot-api.v4"
+)
+
+func TestFilterAccepts(t *testing.T) {
+	private := &tgbotapi.Chat{ID: 1, Type: "private"}
+	group := &tgbotapi.Chat{ID: -1, Type: "group"}
+	text := tgbotapi.Update{Message: &tgbotapi.Message{Chat: private, Text: "hello"}}
+	groupText := tgbotapi.Update{Message: &tgbotapi.Message{Chat: group, Text: "hello"}}
+	gpx := tgbotapi.Update{Message: &tgbotapi.Message{Chat: private, Document: &tgbotapi.Document{FileName: "ride.GPX", MimeType: "application/octet-stream"}}}
+	kml := tgbotapi.Update{Message: &tgbotapi.Message{Chat: private, Document: &tgbotapi.Document{FileName: "ride.bin", MimeType: "application/vnd.google-earth.kml+xml"}}}
+	photo := tgbotapi.Update{Message: &tgbotapi.Message{Chat: private, Document: &tgbotapi.Document{FileName: "photo.jpg", MimeType: "image/jpeg"}}}
+	callback := tgbotapi.Update{CallbackQuery: &tgbotapi.CallbackQuery{Data: "conv:1|x", Message: &tgbotapi.Message{Chat: private}}}
+	inlineCallback := tgbotapi.Update{CallbackQuery: &tgbotapi.CallbackQuery{Data: "conv:1|x"}}
+	edited := tgbotapi.Update{EditedMessage: &tgbotapi.Message{Chat: private, Text: "hello"}}
+	empty := tgbotapi.Update{}
+
+	tracks := Filter{Kinds: KindDocument | KindCallback, Extensions: []string{".gpx"}, MimeTypes: []string{"application/vnd.google-earth.kml+xml"}}
+	privateOnly := Filter{Kinds: KindMessage | KindCallback, ChatTypes: []string{"private"}}
+
+	tests := []struct {
+		name   string
+		filter Filter
+		update tgbotapi.Update
+		want   bool
+	}{
+		{"default text", defaultFilter, text, true},
+		{"default document", defaultFilter, gpx, true},
+		{"default callback", defaultFilter, callback, true},
+		{"default edited", defaultFilter, edited, false},
+		{"default empty", defaultFilter, empty, false},
+		{"no kinds", Filter{}, text, false},
+		{"edited", Filter{Kinds: KindEditedMessage}, edited, true},
+		{"document by extension", tracks, gpx, true},
+		{"document by mime type", tracks, kml, true},
+		{"other document", tracks, photo, false},
+		{"text to documents", tracks, text, false},
+		{"callback to documents", tracks, callback, true},
+		{"any document", Filter{Kinds: KindDocument}, photo, true},
+		{"message takes any document", Filter{Kinds: KindMessage, Extensions: []string{".gpx"}}, photo, true},
+		{"private chat", privateOnly, text, true},
+		{"group chat", privateOnly, groupText, false},
+		{"callback in private chat", privateOnly, callback, true},
+		{"callback without chat", privateOnly, inlineCallback, false},
+		{"chat type case", Filter{Kinds: KindMessage, ChatTypes: []string{"Group"}}, groupText, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.filter.Accepts(tt.update); got != tt.want {
+				t.Errorf("Accepts is %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
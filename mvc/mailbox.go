@@ -0,0 +1,83 @@
+package mvc
+
+import (
+	"sync"
+
+	"gopkg.in/telegram-bot-api.v4"
+)
+
+// Mailboxes run jobs with the same key one by one in order of posting, while
+// jobs with different keys run in parallel.
+type Mailboxes struct {
+	mu     sync.Mutex
+	queues map[int64][]func()
+}
+
+func NewMailboxes() *Mailboxes {
+	return &Mailboxes{queues: make(map[int64][]func())}
+}
+
+// Post queues the job and returns without waiting for it.
+func (b *Mailboxes) Post(key int64, job func()) {
+	b.mu.Lock()
+	q := b.queues[key]
+	b.queues[key] = append(q, job)
+	b.mu.Unlock()
+
+	// The queue is removed when it becomes empty, otherwise it is being run already
+	if len(q) == 0 {
+		go b.run(key)
+	}
+}
+
+// Len returns the number of jobs which wait or run.
+func (b *Mailboxes) Len() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	n := 0
+	for _, q := range b.queues {
+		n += len(q)
+	}
+	return n
+}
+
+func (b *Mailboxes) run(key int64) {
+	for {
+		b.mu.Lock()
+		q := b.queues[key]
+		if len(q) == 0 {
+			delete(b.queues, key)
+			b.mu.Unlock()
+			return
+		}
+		job := q[0]
+		b.mu.Unlock()
+
+		job()
+
+		b.mu.Lock()
+		b.queues[key] = b.queues[key][1:]
+		b.mu.Unlock()
+	}
+}
+
+// Enqueue dispatches the update in the mailbox of its user, so updates of one
+// user are handled in order and different users are handled in parallel.
+// It has to be called in the order updates arrive.
+func (m *Router) Enqueue(update tgbotapi.Update) {
+	user := UpdateUser(update)
+	if user == nil {
+		go m.Dispatch(update)
+		return
+	}
+	m.mailboxes.Post(int64(user.ID), func() {
+		m.Dispatch(update)
+	})
+}
+
+// EnqueueJob runs the job in the mailbox of the user after updates of the
+// user which are queued already, so the job does not race with them.
+func (m *Router) EnqueueJob(userId int, job func()) {
+	m.mailboxes.Post(int64(userId), job)
+}
@@ -0,0 +1,190 @@
+package mvc
+
+import (
+	"log"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"gopkg.in/telegram-bot-api.v4"
+)
+
+// Handler processes an update.
+type Handler func(update tgbotapi.Update)
+
+// Middleware wraps a handler to do something before or after it, or to stop the update.
+type Middleware func(next Handler) Handler
+
+// Use adds middlewares to the dispatch pipeline. The first added runs first.
+// Middlewares should be added before updates are dispatched.
+func (m *Router) Use(middlewares ...Middleware) {
+	m.middlewares = append(m.middlewares, middlewares...)
+}
+
+func (m *Router) pipeline() Handler {
+	h := Handler(m.dispatch)
+	for i := len(m.middlewares) - 1; i >= 0; i-- {
+		h = m.middlewares[i](h)
+	}
+	return h
+}
+
+// UpdateMessage returns the message of any kind carried by the update.
+func UpdateMessage(update tgbotapi.Update) *tgbotapi.Message {
+	switch {
+	case update.Message != nil:
+		return update.Message
+	case update.EditedMessage != nil:
+		return update.EditedMessage
+	case update.ChannelPost != nil:
+		return update.ChannelPost
+	case update.EditedChannelPost != nil:
+		return update.EditedChannelPost
+	case update.CallbackQuery != nil:
+		return update.CallbackQuery.Message
+	}
+	return nil
+}
+
+// UpdateUser returns the user who caused the update.
+func UpdateUser(update tgbotapi.Update) *tgbotapi.User {
+	switch {
+	case update.CallbackQuery != nil:
+		return update.CallbackQuery.From
+	case update.InlineQuery != nil:
+		return update.InlineQuery.From
+	case update.ChosenInlineResult != nil:
+		return update.ChosenInlineResult.From
+	case update.ShippingQuery != nil:
+		return update.ShippingQuery.From
+	case update.PreCheckoutQuery != nil:
+		return update.PreCheckoutQuery.From
+	}
+	if message := UpdateMessage(update); message != nil {
+		return message.From
+	}
+	return nil
+}
+
+// UpdateChat returns the chat where the update happened.
+func UpdateChat(update tgbotapi.Update) *tgbotapi.Chat {
+	if message := UpdateMessage(update); message != nil {
+		return message.Chat
+	}
+	return nil
+}
+
+// Logging logs every incoming update.
+func Logging() Middleware {
+	return func(next Handler) Handler {
+		return func(update tgbotapi.Update) {
+			start := time.Now()
+			user := "-"
+			if u := UpdateUser(update); u != nil {
+				user = u.UserName
+			}
+			switch {
+			case update.Message != nil:
+				log.Printf("[%s] => %s\n", user, update.Message.Text)
+			case update.CallbackQuery != nil:
+				log.Printf("[%s] => callback %s\n", user, update.CallbackQuery.Data)
+			}
+			next(update)
+			log.Printf("Update %d handled in %s\n", update.UpdateID, time.Since(start))
+		}
+	}
+}
+
+// Recover stops a panic from killing the process and logs it with the stack.
+// The callback of the update is answered by the router.
+func Recover(router *Router) Middleware {
+	return func(next Handler) Handler {
+		return func(update tgbotapi.Update) {
+			defer func() {
+				if r := recover(); r != nil {
+					log.Printf("PANIC in update %d: %v\n%s", update.UpdateID, r, debug.Stack())
+					router.answerDropped(update, panicAnswerText)
+				}
+			}()
+			next(update)
+		}
+	}
+}
+
+// ChatTypes passes only updates from the given chat types, e.g. "private" or
+// "group". Updates without a chat, such as inline queries, pass too.
+func ChatTypes(types ...string) Middleware {
+	return func(next Handler) Handler {
+		return func(update tgbotapi.Update) {
+			chat := UpdateChat(update)
+			if chat == nil {
+				next(update)
+				return
+			}
+			for _, t := range types {
+				if chat.Type == t {
+					next(update)
+					return
+				}
+			}
+		}
+	}
+}
+
+// AdminsOnly passes updates from Router admins only.
+func AdminsOnly(router *Router) Middleware {
+	return func(next Handler) Handler {
+		return func(update tgbotapi.Update) {
+			if user := UpdateUser(update); user != nil && router.IsAdmin(user.ID) {
+				next(update)
+			}
+		}
+	}
+}
+
+const rateLimitedText = "Слишком много запросов, подождите немного"
+
+// RateLimit drops updates of a user who sent more than limit updates during
+// interval. Dropped callbacks are answered by the router.
+func RateLimit(router *Router, limit int, interval time.Duration) Middleware {
+	type window struct {
+		start time.Time
+		count int
+	}
+	var mu sync.Mutex
+	windows := make(map[int]*window)
+
+	return func(next Handler) Handler {
+		return func(update tgbotapi.Update) {
+			user := UpdateUser(update)
+			if user == nil {
+				next(update)
+				return
+			}
+
+			mu.Lock()
+			now := time.Now()
+			w, ok := windows[user.ID]
+			if !ok || now.Sub(w.start) > interval {
+				// Forget users who are quiet now, before adding another one
+				for id, old := range windows {
+					if now.Sub(old.start) > interval {
+						delete(windows, id)
+					}
+				}
+				w = &window{start: now}
+				windows[user.ID] = w
+			}
+			w.count++
+			limited := w.count > limit
+			mu.Unlock()
+
+			if limited {
+				log.Printf("Rate limit: dropping update %d from user %d\n", update.UpdateID, user.ID)
+				router.answerDropped(update, rateLimitedText)
+				return
+			}
+			next(update)
+		}
+	}
+}
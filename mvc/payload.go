@@ -0,0 +1,194 @@
+package mvc
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Telegram limit for callback_data
+	maxCallbackData = 64
+	// Marks a payload token in callback data
+	payloadTokenPrefix = "~"
+	payloadTokenBytes  = 6
+	// Tokens are random, so a free one is found at once unless the source is broken
+	payloadTokenAttempts = 10
+	payloadFlushPeriod   = 10 * time.Second
+)
+
+type storedPayload struct {
+	Data      string    `json:"data"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// PayloadStore keeps button payloads on the server, so callback data carries
+// a short token only. Payloads expire after ttl and are saved to disk periodically.
+// Equal payloads share a token, so keyboards rendered again don't grow the store.
+type PayloadStore struct {
+	mu       sync.Mutex
+	path     string
+	ttl      time.Duration
+	dirty    bool
+	payloads map[string]*storedPayload
+	// Tokens by sha256 of their payloads
+	tokens map[[sha256.Size]byte]string
+}
+
+func NewPayloadStore(path string, ttl time.Duration) *PayloadStore {
+	s := &PayloadStore{}
+	s.path = path
+	s.ttl = ttl
+	s.payloads = make(map[string]*storedPayload)
+	s.tokens = make(map[[sha256.Size]byte]string)
+	s.load()
+	go s.flushLoop()
+	return s
+}
+
+var errNoPayloadToken = errors.New("no free payload token")
+
+// Put stores the payload and returns its token. The token of an equal payload
+// stored earlier is returned with its expiration renewed.
+func (s *PayloadStore) Put(data string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	hash := sha256.Sum256([]byte(data))
+	if token, ok := s.tokens[hash]; ok {
+		s.payloads[token].ExpiresAt = time.Now().Add(s.ttl)
+		s.dirty = true
+		return token, nil
+	}
+
+	token := ""
+	for i := 0; i < payloadTokenAttempts && token == ""; i++ {
+		b := make([]byte, payloadTokenBytes)
+		if _, err := rand.Read(b); err != nil {
+			return "", err
+		}
+		token = base64.RawURLEncoding.EncodeToString(b)
+		if _, taken := s.payloads[token]; taken {
+			token = ""
+		}
+	}
+	if token == "" {
+		return "", errNoPayloadToken
+	}
+	s.payloads[token] = &storedPayload{Data: data, ExpiresAt: time.Now().Add(s.ttl)}
+	s.tokens[hash] = token
+	s.dirty = true
+	return token, nil
+}
+
+// Get returns the payload stored with the token, false if it is unknown or expired.
+func (s *PayloadStore) Get(token string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, ok := s.payloads[token]
+	if !ok || time.Now().After(p.ExpiresAt) {
+		return "", false
+	}
+	return p.Data, true
+}
+
+// Flush removes expired payloads and saves the store if it was changed.
+func (s *PayloadStore) Flush() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for token, p := range s.payloads {
+		if now.After(p.ExpiresAt) {
+			delete(s.payloads, token)
+			if hash := sha256.Sum256([]byte(p.Data)); s.tokens[hash] == token {
+				delete(s.tokens, hash)
+			}
+			s.dirty = true
+		}
+	}
+	if s.dirty {
+		s.save()
+		s.dirty = false
+	}
+}
+
+func (s *PayloadStore) flushLoop() {
+	for range time.Tick(payloadFlushPeriod) {
+		s.Flush()
+	}
+}
+
+func (s *PayloadStore) save() {
+	b, err := json.Marshal(s.payloads)
+	if err != nil {
+		log.Printf("PAYLOAD SAVE ERR: %s\n", err)
+		return
+	}
+	tmp := s.path + ".tmp"
+	if err = ioutil.WriteFile(tmp, b, 0644); err != nil {
+		log.Printf("PAYLOAD SAVE ERR: %s\n", err)
+		return
+	}
+	if err = os.Rename(tmp, s.path); err != nil {
+		log.Printf("PAYLOAD SAVE ERR: %s\n", err)
+	}
+}
+
+func (s *PayloadStore) load() {
+	b, err := ioutil.ReadFile(s.path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("PAYLOAD LOAD ERR: %s\n", err)
+		}
+		return
+	}
+	if err = json.Unmarshal(b, &s.payloads); err != nil {
+		log.Printf("PAYLOAD LOAD ERR: %s\n", err)
+	}
+	for token, p := range s.payloads {
+		s.tokens[sha256.Sum256([]byte(p.Data))] = token
+	}
+}
+
+// CallbackData builds callback data for the controller button. Data longer
+// than Telegram allows is kept in the payload store and replaced with a token.
+// If it can't be stored, the data is returned as is and Telegram rejects the button.
+func (m *Router) CallbackData(namespace string, version int, parts ...string) string {
+	data := CallbackData(namespace, version, parts...)
+	if len(data) <= maxCallbackData || m.Payloads == nil {
+		return data
+	}
+	token, err := m.Payloads.Put(strings.Join(parts, "|"))
+	if err != nil {
+		log.Printf("PAYLOAD TOKEN ERR: %s\n", err)
+		return data
+	}
+	return CallbackData(namespace, version, payloadTokenPrefix+token)
+}
+
+// resolveCallbackData puts the stored payload back in place of its token.
+// It returns false if the payload has expired.
+func (m *Router) resolveCallbackData(data string) (string, bool) {
+	parts := strings.SplitN(data, "|", 2)
+	if len(parts) < 2 || !strings.HasPrefix(parts[1], payloadTokenPrefix) {
+		return data, true
+	}
+	if m.Payloads == nil {
+		return "", false
+	}
+	payload, ok := m.Payloads.Get(strings.TrimPrefix(parts[1], payloadTokenPrefix))
+	if !ok {
+		return "", false
+	}
+	return parts[0] + "|" + payload, true
+}
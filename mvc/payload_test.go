@@ -0,0 +1,104 @@
+package mvc
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestPayloadStore(t *testing.T) *PayloadStore {
+	return NewPayloadStore(filepath.Join(t.TempDir(), "payloads.json"), time.Hour)
+}
+
+func TestPayloadStore(t *testing.T) {
+	s := newTestPayloadStore(t)
+	first, err := s.Put("convert|ride.gpx|.kml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := s.Put("convert|ride.gpx|.plt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first == other {
+		t.Fatalf("Different payloads share token %s", first)
+	}
+
+	// Reused with the expiration renewed
+	s.payloads[first].ExpiresAt = time.Now().Add(time.Minute)
+	again, err := s.Put("convert|ride.gpx|.kml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again != first {
+		t.Errorf("Equal payload got token %s, want %s", again, first)
+	}
+	if s.payloads[first].ExpiresAt.Before(time.Now().Add(time.Minute)) {
+		t.Error("Expiration is not renewed")
+	}
+
+	// Expired payloads are not returned, flushed ones get new tokens
+	s.payloads[first].ExpiresAt = time.Now().Add(-time.Second)
+	if _, ok := s.Get(first); ok {
+		t.Error("Expired payload is returned")
+	}
+	s.Flush()
+	if _, ok := s.payloads[first]; ok {
+		t.Error("Expired payload is not flushed")
+	}
+	renewed, err := s.Put("convert|ride.gpx|.kml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, ok := s.Get(renewed); !ok || data != "convert|ride.gpx|.kml" {
+		t.Errorf("Stored again as %q %v", data, ok)
+	}
+	if data, ok := s.Get(other); !ok || data != "convert|ride.gpx|.plt" {
+		t.Errorf("Kept payload read as %q %v", data, ok)
+	}
+
+	// Saved by Flush and loaded with the token index
+	loaded := NewPayloadStore(s.path, time.Hour)
+	if data, ok := loaded.Get(other); !ok || data != "convert|ride.gpx|.plt" {
+		t.Errorf("Loaded payload read as %q %v", data, ok)
+	}
+	if token, _ := loaded.Put("convert|ride.gpx|.plt"); token != other {
+		t.Errorf("Loaded payload got token %s, want %s", token, other)
+	}
+}
+
+func TestResolveCallbackData(t *testing.T) {
+	m := &Router{Payloads: newTestPayloadStore(t)}
+	long := strings.Repeat("x", maxCallbackData)
+	stored := m.CallbackData("conv", 1, "convert", long)
+	if len(stored) > maxCallbackData || !strings.HasPrefix(stored, "conv:1|"+payloadTokenPrefix) {
+		t.Fatalf("Long data is built as %s", stored)
+	}
+	expired := m.CallbackData("conv", 1, "convert", long+"y")
+	m.Payloads.payloads[strings.TrimPrefix(expired, "conv:1|"+payloadTokenPrefix)].ExpiresAt = time.Now().Add(-time.Second)
+
+	tests := []struct {
+		name   string
+		router *Router
+		data   string
+		want   string
+		wantOk bool
+	}{
+		{"plain", m, "conv:1|convert|.gpx", "conv:1|convert|.gpx", true},
+		{"no parts", m, "conv:1", "conv:1", true},
+		{"stored", m, stored, "conv:1|convert|" + long, true},
+		{"expired", m, expired, "", false},
+		{"unknown token", m, "conv:1|~unknown", "", false},
+		{"no store", &Router{}, stored, "", false},
+		{"plain without store", &Router{}, "conv:1|convert", "conv:1|convert", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := tt.router.resolveCallbackData(tt.data)
+			if got != tt.want || ok != tt.wantOk {
+				t.Errorf("Resolved as %q %v, want %q %v", got, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+}
@@ -0,0 +1,58 @@
+package mvc
+
+import (
+	"fmt"
+	"log"
+	"runtime/debug"
+	"time"
+
+	"gopkg.in/telegram-bot-api.v4"
+)
+
+const (
+	// Admins get at most one panic report per controller during this interval
+	panicReportInterval = time.Minute
+	panicReportLength   = 3000
+	panicAnswerText     = "Не удалось обработать запрос, попробуйте позже"
+)
+
+// isolate runs the controller code and recovers its panic, so a failing
+// controller can't stop the others or crash the bot.
+func (m *Router) isolate(name string, update tgbotapi.Update, fn func()) {
+	defer func() {
+		r := recover()
+		if r == nil {
+			return
+		}
+		stack := string(debug.Stack())
+		log.Printf("PANIC in %s on update %d: %v\n%s", name, update.UpdateID, r, stack)
+
+		if r := []rune(stack); len(r) > panicReportLength {
+			stack = string(r[:panicReportLength]) + "..."
+		}
+		m.reportPanic(name, fmt.Sprintf("Ошибка в %s при обработке обновления %d: %v\n\n%s", name, update.UpdateID, r, stack))
+		m.answerDropped(update, panicAnswerText)
+	}()
+	fn()
+}
+
+func (m *Router) reportPanic(name, text string) {
+	m.panicLock.Lock()
+	if m.panicReported == nil {
+		m.panicReported = make(map[string]time.Time)
+	}
+	last := m.panicReported[name]
+	throttled := time.Since(last) < panicReportInterval
+	if !throttled {
+		m.panicReported[name] = time.Now()
+	}
+	m.panicLock.Unlock()
+	if throttled {
+		return
+	}
+
+	// Private chat ID is the same as the user ID
+	for _, id := range m.Admins {
+		m.Send(tgbotapi.NewMessage(int64(id), text))
+	}
+}
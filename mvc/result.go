@@ -0,0 +1,40 @@
+package mvc
+
+import (
+	"gopkg.in/telegram-bot-api.v4"
+)
+
+// ResultCallback gets the message returned by Telegram for the sent result or the sending error.
+type ResultCallback func(message tgbotapi.Message, err error)
+
+// Result is an outgoing request: a message, an upload, an edit, a chat action etc.
+// Callback query answers are not Chattable in the API library, so they are kept in Answer.
+type Result struct {
+	Chattable tgbotapi.Chattable
+	Answer    *tgbotapi.CallbackConfig
+	// Optional, called by the sender after the request is done
+	Callback ResultCallback
+}
+
+func NewResult(c tgbotapi.Chattable, callback ResultCallback) Result {
+	return Result{Chattable: c, Callback: callback}
+}
+
+func NewAnswerResult(answer tgbotapi.CallbackConfig) Result {
+	return Result{Answer: &answer}
+}
+
+// Deliver sends the result and passes the outcome to its callback.
+func (r Result) Deliver(bot *tgbotapi.BotAPI) (tgbotapi.Message, error) {
+	var message tgbotapi.Message
+	var err error
+	if r.Answer != nil {
+		_, err = bot.AnswerCallbackQuery(*r.Answer)
+	} else {
+		message, err = bot.Send(r.Chattable)
+	}
+	if r.Callback != nil {
+		r.Callback(message, err)
+	}
+	return message, err
+}
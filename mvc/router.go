@@ -2,64 +2,171 @@ package mvc
 
 import (
 	"log"
-	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/nolka/gooffroadmaster/util"
 	"gopkg.in/telegram-bot-api.v4"
 )
 
 type BotMessageComponentInterface interface {
-	SetId(id int)
+	// GetNamespace returns a stable unique name, it prefixes callback data of the controller
+	GetNamespace() string
 	GetName() string
 	Init(manager *Router)
 	HandleMessage(update tgbotapi.Update)
 	HandleCallback(update tgbotapi.Update)
 }
 
-func NewMessageRouter(bot *tgbotapi.BotAPI, results chan tgbotapi.MessageConfig) *Router {
+func NewMessageRouter(bot *tgbotapi.BotAPI, results chan Result) *Router {
 	cm := &Router{}
 	cm.Bot = bot
 	cm.Results = results
-	cm.Controllers = make(map[int]BotMessageComponentInterface)
+	cm.Controllers = make(map[string]BotMessageComponentInterface)
+	cm.Commands = make(map[string]*Command)
+	cm.mailboxes = NewMailboxes()
+	cm.RegisterCommand(cm.helpCommand())
 	return cm
 }
 
 type Router struct {
 	Bot         *tgbotapi.BotAPI
-	Results     chan tgbotapi.MessageConfig
-	Controllers map[int]BotMessageComponentInterface
+	Results     chan Result
+	Controllers map[string]BotMessageComponentInterface
+	Commands    map[string]*Command
+	// Telegram IDs of users allowed to run admin commands
+	Admins []int
+	Policy DispatchPolicy
+	// Optional, keeps callback data which doesn't fit into a button
+	Payloads *PayloadStore
+
+	order         []BotMessageComponentInterface
+	mailboxes     *Mailboxes
+	middlewares   []Middleware
+	panicLock     sync.Mutex
+	panicReported map[string]time.Time
+}
+
+func (m *Router) IsAdmin(userId int) bool {
+	for _, id := range m.Admins {
+		if id == userId {
+			return true
+		}
+	}
+	return false
+}
+
+// Send queues c for sending without waiting for it.
+func (m *Router) Send(c tgbotapi.Chattable) {
+	m.Results <- NewResult(c, nil)
+}
+
+// SendWithCallback queues c for sending, callback gets the outcome.
+func (m *Router) SendWithCallback(c tgbotapi.Chattable, callback ResultCallback) {
+	m.Results <- NewResult(c, callback)
 }
 
-func (m *Router) GetControllers() map[int]BotMessageComponentInterface {
+// SendSync queues c and waits until it is sent. It must not be called from a
+// ResultCallback, the sender would wait for itself.
+func (m *Router) SendSync(c tgbotapi.Chattable) (tgbotapi.Message, error) {
+	type outcome struct {
+		message tgbotapi.Message
+		err     error
+	}
+	done := make(chan outcome, 1)
+	m.SendWithCallback(c, func(message tgbotapi.Message, err error) {
+		done <- outcome{message, err}
+	})
+	o := <-done
+	return o.message, o.err
+}
+
+// AnswerCallback queues the answer to a callback query.
+func (m *Router) AnswerCallback(answer tgbotapi.CallbackConfig) {
+	m.Results <- NewAnswerResult(answer)
+}
+
+func (m *Router) GetControllers() map[string]BotMessageComponentInterface {
 	return m.Controllers
 }
 
 func (m *Router) RegisterController(component BotMessageComponentInterface) {
-	component.SetId(len(m.Controllers))
-	m.Controllers[len(m.Controllers)] = component
+	namespace := component.GetNamespace()
+	if _, ok := m.Controllers[namespace]; ok || strings.ContainsAny(namespace, ":|") {
+		log.Panicf("Bad or duplicate controller namespace: %s", namespace)
+	}
+	m.Controllers[namespace] = component
+	m.order = append(m.order, component)
 }
 
+// Dispatch passes the update through middlewares to controllers.
 func (m *Router) Dispatch(update tgbotapi.Update) {
+	m.pipeline()(update)
+}
+
+func (m *Router) dispatch(update tgbotapi.Update) {
 	if update.CallbackQuery != nil {
-		parts := strings.SplitN(update.CallbackQuery.Data, "|", 2)
-		componentId, err := strconv.Atoi(parts[0])
-		if err != nil {
-			log.Printf("Failed to get mvc id from string: %s. Skipping...\n", parts[0])
+		c := m.controllerForCallback(update.CallbackQuery.Data)
+		if c == nil {
+			log.Printf("Expired callback data: %s\n", update.CallbackQuery.Data)
+			m.answerExpired(update.CallbackQuery)
 			return
 		}
-		components := m.GetControllers()
-		components[componentId].HandleCallback(update)
+		if !filterOf(c).Accepts(update) {
+			// Nobody handles it, the button spinner still has to be stopped
+			m.AnswerCallback(tgbotapi.NewCallback(update.CallbackQuery.ID, ""))
+			return
+		}
+		data, ok := m.resolveCallbackData(update.CallbackQuery.Data)
+		if !ok {
+			log.Printf("Expired callback payload: %s\n", update.CallbackQuery.Data)
+			m.answerExpired(update.CallbackQuery)
+			return
+		}
+		update.CallbackQuery.Data = data
+		m.isolate(c.GetName(), update, func() {
+			c.HandleCallback(update)
+		})
 		return
 	}
 
-	for _, c := range m.GetControllers() {
-		c.HandleMessage(update)
+	if update.Message != nil {
+		handled := false
+		m.isolate("command handler", update, func() {
+			handled = m.dispatchCommand(update.Message)
+		})
+		if handled {
+			return
+		}
+	}
+
+	for _, c := range m.order {
+		if !filterOf(c).Accepts(update) {
+			continue
+		}
+		m.isolate(c.GetName(), update, func() {
+			c.HandleMessage(update)
+		})
+		if m.Policy == FirstMatch {
+			return
+		}
 	}
 }
 
+// Halter is implemented by controllers which save their state on shutdown.
+type Halter interface {
+	Halt()
+}
+
 func (m *Router) Halt() {
 	for _, c := range m.GetControllers() {
 		util.SaveConfig(c)
+		if h, ok := c.(Halter); ok {
+			h.Halt()
+		}
+	}
+	if m.Payloads != nil {
+		m.Payloads.Flush()
 	}
 }
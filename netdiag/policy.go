@@ -0,0 +1,101 @@
+package netdiag
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+)
+
+// Ranges which must not be probed by default: the bot host and its private networks
+var defaultDenied = []string{
+	"0.0.0.0/8",
+	"10.0.0.0/8",
+	"100.64.0.0/10",
+	"127.0.0.0/8",
+	"169.254.0.0/16",
+	"172.16.0.0/12",
+	"192.0.0.0/24",
+	"192.168.0.0/16",
+	"198.18.0.0/15",
+	"224.0.0.0/4",
+	"240.0.0.0/4",
+	"::/128",
+	"::1/128",
+	// NAT64 and 6to4 addresses embed IPv4 ones, private included
+	"64:ff9b::/96",
+	"2002::/16",
+	"fc00::/7",
+	"fe80::/10",
+	"ff00::/8",
+}
+
+var hostnamePattern = regexp.MustCompile(`^([a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?\.)*[a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?\.?$`)
+
+var ErrInvalidHost = errors.New("invalid host name")
+
+// ValidateHost accepts IP addresses and RFC 1123 host names only.
+func ValidateHost(host string) error {
+	if net.ParseIP(host) != nil {
+		return nil
+	}
+	if len(host) > 253 || !hostnamePattern.MatchString(host) {
+		return ErrInvalidHost
+	}
+	return nil
+}
+
+// Policy tells which addresses may be probed. Allowed ranges take precedence
+// over denied ones, so a single private host can be opened.
+type Policy struct {
+	Allow []*net.IPNet
+	Deny  []*net.IPNet
+}
+
+// NewPolicy parses CIDR lists, denied ranges are added to the default ones.
+func NewPolicy(allow, deny []string) (*Policy, error) {
+	p := &Policy{}
+	var err error
+	if p.Allow, err = parseNets(allow); err != nil {
+		return nil, err
+	}
+	if p.Deny, err = parseNets(append(append([]string{}, defaultDenied...), deny...)); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func parseNets(cidrs []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, cidr := range cidrs {
+		if !strings.Contains(cidr, "/") {
+			if strings.Contains(cidr, ":") {
+				cidr += "/128"
+			} else {
+				cidr += "/32"
+			}
+		}
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// Check returns an error if the address may not be probed.
+func (p *Policy) Check(ip net.IP) error {
+	for _, n := range p.Allow {
+		if n.Contains(ip) {
+			return nil
+		}
+	}
+	for _, n := range p.Deny {
+		if n.Contains(ip) {
+			return fmt.Errorf("address %s is not allowed", ip)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package netdiag
+
+import (
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestValidateHost(t *testing.T) {
+	tests := []struct {
+		host string
+		ok   bool
+	}{
+		{"example.com", true},
+		{"example.com.", true},
+		{"my-host.example.com", true},
+		{"localhost", true},
+		{"93.184.216.34", true},
+		{"2606:2800:220:1::248", true},
+		{"::ffff:127.0.0.1", true},
+		{"", false},
+		{"-example.com", false},
+		{"example-.com", false},
+		{"exa mple.com", false},
+		{"example.com; rm -rf /", false},
+		{"example..com", false},
+		{"http://example.com", false},
+		{strings.Repeat("a", 64) + ".com", false},
+		{strings.Repeat("a.", 127) + "aa", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			err := ValidateHost(tt.host)
+			if (err == nil) != tt.ok {
+				t.Errorf("Validated with %v, want ok %v", err, tt.ok)
+			}
+		})
+	}
+}
+
+func TestPolicyCheck(t *testing.T) {
+	policy, err := NewPolicy([]string{"10.1.2.3", "fd00::1"}, []string{"93.184.216.0/24"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		ip string
+		ok bool
+	}{
+		{"8.8.8.8", true},
+		{"2001:4860:4860::8888", true},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"0.0.0.0", false},
+		{"::", false},
+		{"10.0.0.1", false},
+		{"172.16.5.4", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false},
+		{"100.64.0.1", false},
+		{"fc00::1", false},
+		{"fe80::1", false},
+		{"ff02::1", false},
+		{"::ffff:127.0.0.1", false},
+		{"::ffff:192.168.1.1", false},
+		{"::ffff:8.8.8.8", true},
+		{"64:ff9b::7f00:1", false},
+		{"2002:7f00:1::1", false},
+		// Additionally denied
+		{"93.184.216.34", false},
+		// Allowed take precedence over denied
+		{"10.1.2.3", true},
+		{"fd00::1", true},
+		{"fd00::2", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.ip, func(t *testing.T) {
+			ip := net.ParseIP(tt.ip)
+			if ip == nil {
+				t.Fatalf("Bad test address %s", tt.ip)
+			}
+			err := policy.Check(ip)
+			if (err == nil) != tt.ok {
+				t.Errorf("Checked with %v, want ok %v", err, tt.ok)
+			}
+		})
+	}
+}
+
+func TestNewPolicyBadRange(t *testing.T) {
+	if _, err := NewPolicy(nil, []string{"10.0.0.0/33"}); err == nil {
+		t.Error("Bad range is accepted")
+	}
+}
@@ -0,0 +1,217 @@
+package netdiag
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	icmpEchoRequest = 8
+	icmpEchoReply   = 0
+	icmpHeaderSize  = 8
+	probeInterval   = time.Second
+)
+
+// Stats are round trip times of a series of probes.
+type Stats struct {
+	Method   string
+	Address  string
+	Sent     int
+	Received int
+	RTTs     []time.Duration
+}
+
+func (s *Stats) add(rtt time.Duration) {
+	s.Received++
+	s.RTTs = append(s.RTTs, rtt)
+}
+
+func (s *Stats) Min() time.Duration {
+	var min time.Duration
+	for i, r := range s.RTTs {
+		if i == 0 || r < min {
+			min = r
+		}
+	}
+	return min
+}
+
+func (s *Stats) Max() time.Duration {
+	var max time.Duration
+	for _, r := range s.RTTs {
+		if r > max {
+			max = r
+		}
+	}
+	return max
+}
+
+func (s *Stats) Avg() time.Duration {
+	if len(s.RTTs) == 0 {
+		return 0
+	}
+	var total time.Duration
+	for _, r := range s.RTTs {
+		total += r
+	}
+	return total / time.Duration(len(s.RTTs))
+}
+
+func (s *Stats) StdDev() time.Duration {
+	if len(s.RTTs) == 0 {
+		return 0
+	}
+	avg := float64(s.Avg())
+	var sum float64
+	for _, r := range s.RTTs {
+		sum += (float64(r) - avg) * (float64(r) - avg)
+	}
+	return time.Duration(math.Sqrt(sum / float64(len(s.RTTs))))
+}
+
+// Loss is the share of probes without an answer in percent.
+func (s *Stats) Loss() float64 {
+	if s.Sent == 0 {
+		return 0
+	}
+	return float64(s.Sent-s.Received) / float64(s.Sent) * 100
+}
+
+func (s *Stats) String() string {
+	b := &strings.Builder{}
+	fmt.Fprintf(b, "%s %s: sent %d, received %d, loss %.0f%%\n", s.Method, s.Address, s.Sent, s.Received, s.Loss())
+	if s.Received > 0 {
+		fmt.Fprintf(b, "rtt min/avg/max/mdev = %s/%s/%s/%s\n",
+			roundRTT(s.Min()), roundRTT(s.Avg()), roundRTT(s.Max()), roundRTT(s.StdDev()))
+	}
+	return b.String()
+}
+
+func roundRTT(d time.Duration) time.Duration {
+	return d.Round(10 * time.Microsecond)
+}
+
+// Resolve looks the host up and checks every address against the policy.
+// Probes must use returned addresses, not the name, so the name can't be
+// re-resolved to a denied address later.
+func Resolve(ctx context.Context, host string, policy *Policy) ([]net.IP, time.Duration, error) {
+	if err := ValidateHost(host); err != nil {
+		return nil, 0, err
+	}
+	start := time.Now()
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	elapsed := time.Since(start)
+	if err != nil {
+		return nil, elapsed, err
+	}
+
+	var ips []net.IP
+	for _, a := range addrs {
+		if err = policy.Check(a.IP); err != nil {
+			return nil, elapsed, err
+		}
+		ips = append(ips, a.IP)
+	}
+	return ips, elapsed, nil
+}
+
+// ErrICMPNotPermitted is returned when the process may not open raw sockets.
+var ErrICMPNotPermitted = errors.New("ICMP is not permitted")
+
+// PingICMP sends ICMP echo requests to an IPv4 address. It needs a raw socket,
+// so the bot has to run as root or with CAP_NET_RAW.
+func PingICMP(ip net.IP, count int, timeout time.Duration) (*Stats, error) {
+	if ip.To4() == nil {
+		return nil, ErrICMPNotPermitted
+	}
+	conn, err := net.ListenPacket("ip4:icmp", "0.0.0.0")
+	if err != nil {
+		if os.IsPermission(err) || strings.Contains(err.Error(), "operation not permitted") {
+			return nil, ErrICMPNotPermitted
+		}
+		return nil, err
+	}
+	defer conn.Close()
+
+	stats := &Stats{Method: "ICMP", Address: ip.String()}
+	id := uint16(os.Getpid())
+	dst := &net.IPAddr{IP: ip}
+	reply := make([]byte, 1500)
+	for seq := 1; seq <= count; seq++ {
+		stats.Sent++
+		start := time.Now()
+		if _, err = conn.WriteTo(echoRequest(id, uint16(seq)), dst); err != nil {
+			return nil, err
+		}
+
+		deadline := start.Add(timeout)
+		conn.SetReadDeadline(deadline)
+		for {
+			n, from, err := conn.ReadFrom(reply)
+			if err != nil {
+				break
+			}
+			if isEchoReply(reply[:n], id, uint16(seq)) && from.(*net.IPAddr).IP.Equal(ip) {
+				stats.add(time.Since(start))
+				break
+			}
+		}
+		if seq < count {
+			time.Sleep(time.Until(start.Add(probeInterval)))
+		}
+	}
+	return stats, nil
+}
+
+func echoRequest(id, seq uint16) []byte {
+	b := make([]byte, icmpHeaderSize+8)
+	b[0] = icmpEchoRequest
+	binary.BigEndian.PutUint16(b[4:], id)
+	binary.BigEndian.PutUint16(b[6:], seq)
+	binary.BigEndian.PutUint64(b[8:], uint64(time.Now().UnixNano()))
+	binary.BigEndian.PutUint16(b[2:], checksum(b))
+	return b
+}
+
+func isEchoReply(b []byte, id, seq uint16) bool {
+	return len(b) >= icmpHeaderSize && b[0] == icmpEchoReply &&
+		binary.BigEndian.Uint16(b[4:]) == id && binary.BigEndian.Uint16(b[6:]) == seq
+}
+
+func checksum(b []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(b); i += 2 {
+		sum += uint32(b[i])<<8 | uint32(b[i+1])
+	}
+	if len(b)%2 == 1 {
+		sum += uint32(b[len(b)-1]) << 8
+	}
+	for sum>>16 != 0 {
+		sum = sum&0xffff + sum>>16
+	}
+	return ^uint16(sum)
+}
+
+// PingTCP measures how long it takes to establish a TCP connection to the port.
+func PingTCP(ip net.IP, port, count int, timeout time.Duration) *Stats {
+	address := net.JoinHostPort(ip.String(), fmt.Sprint(port))
+	stats := &Stats{Method: "TCP", Address: address}
+	for i := 0; i < count; i++ {
+		stats.Sent++
+		start := time.Now()
+		conn, err := net.DialTimeout("tcp", address, timeout)
+		if err != nil {
+			continue
+		}
+		stats.add(time.Since(start))
+		conn.Close()
+	}
+	return stats
+}
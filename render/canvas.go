@@ -0,0 +1,86 @@
+package render
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"math"
+)
+
+var (
+	White     = color.RGBA{255, 255, 255, 255}
+	Black     = color.RGBA{0, 0, 0, 255}
+	Gray      = color.RGBA{128, 128, 128, 255}
+	LightGray = color.RGBA{225, 225, 225, 255}
+	Green     = color.RGBA{0, 160, 0, 255}
+	Red       = color.RGBA{220, 0, 0, 255}
+	Blue      = color.RGBA{0, 90, 220, 255}
+)
+
+// Canvas is an RGBA image with a few primitive drawing operations.
+type Canvas struct {
+	*image.RGBA
+}
+
+func NewCanvas(width, height int, background color.Color) *Canvas {
+	c := &Canvas{image.NewRGBA(image.Rect(0, 0, width, height))}
+	c.FillRect(c.Bounds(), background)
+	return c
+}
+
+func (c *Canvas) FillRect(r image.Rectangle, col color.Color) {
+	draw.Draw(c.RGBA, r, &image.Uniform{col}, image.ZP, draw.Over)
+}
+
+func (c *Canvas) FillCircle(cx, cy, radius float64, col color.Color) {
+	for y := int(cy - radius); y <= int(cy+radius)+1; y++ {
+		for x := int(cx - radius); x <= int(cx+radius)+1; x++ {
+			dx, dy := float64(x)+0.5-cx, float64(y)+0.5-cy
+			if dx*dx+dy*dy <= radius*radius {
+				c.Set(x, y, col)
+			}
+		}
+	}
+}
+
+// DrawLine draws a line of the given width by stamping circles along it.
+func (c *Canvas) DrawLine(x0, y0, x1, y1, width float64, col color.Color) {
+	radius := math.Max(width/2, 0.5)
+	length := math.Hypot(x1-x0, y1-y0)
+	steps := int(math.Ceil(length * 2))
+	for i := 0; i <= steps; i++ {
+		k := 0.0
+		if steps > 0 {
+			k = float64(i) / float64(steps)
+		}
+		c.FillCircle(x0+(x1-x0)*k, y0+(y1-y0)*k, radius, col)
+	}
+}
+
+func (c *Canvas) DrawVerticalLine(x, y0, y1 int, col color.Color) {
+	if y0 > y1 {
+		y0, y1 = y1, y0
+	}
+	for y := y0; y <= y1; y++ {
+		c.Set(x, y, col)
+	}
+}
+
+func (c *Canvas) DrawHorizontalLine(x0, x1, y int, col color.Color) {
+	if x0 > x1 {
+		x0, x1 = x1, x0
+	}
+	for x := x0; x <= x1; x++ {
+		c.Set(x, y, col)
+	}
+}
+
+func (c *Canvas) EncodePNG() ([]byte, error) {
+	b := &bytes.Buffer{}
+	if err := png.Encode(b, c.RGBA); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
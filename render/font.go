@@ -0,0 +1,69 @@
+package render
+
+import (
+	"image"
+	"image/color"
+	"strings"
+)
+
+const (
+	glyphWidth  = 3
+	glyphHeight = 5
+)
+
+// A tiny 3x5 bitmap font, enough for scale and axis labels.
+var glyphs = map[rune][glyphHeight]string{
+	'0': {"###", "#.#", "#.#", "#.#", "###"},
+	'1': {".#.", "##.", ".#.", ".#.", "###"},
+	'2': {"###", "..#", "###", "#..", "###"},
+	'3': {"###", "..#", ".##", "..#", "###"},
+	'4': {"#.#", "#.#", "###", "..#", "..#"},
+	'5': {"###", "#..", "###", "..#", "###"},
+	'6': {"###", "#..", "###", "#.#", "###"},
+	'7': {"###", "..#", ".#.", ".#.", ".#."},
+	'8': {"###", "#.#", "###", "#.#", "###"},
+	'9': {"###", "#.#", "###", "..#", "###"},
+	'k': {"#..", "#.#", "##.", "#.#", "#.#"},
+	'm': {"...", "##.", "###", "#.#", "#.#"},
+	'%': {"#.#", "..#", ".#.", "#..", "#.#"},
+	'.': {"...", "...", "...", "...", ".#."},
+	'-': {"...", "...", "###", "...", "..."},
+	'+': {"...", ".#.", "###", ".#.", "..."},
+	' ': {"...", "...", "...", "...", "..."},
+}
+
+// TextSize returns the size of the text drawn with the given scale.
+func TextSize(text string, scale int) image.Point {
+	n := len([]rune(text))
+	if n == 0 {
+		return image.Point{}
+	}
+	return image.Pt((n*(glyphWidth+1)-1)*scale, glyphHeight*scale)
+}
+
+// DrawText draws the text with its top left corner at x, y. Unknown characters are skipped.
+func (c *Canvas) DrawText(x, y int, text string, scale int, col color.Color) {
+	for _, r := range text {
+		glyph, ok := glyphs[r]
+		if !ok {
+			glyph = glyphs[' ']
+		}
+		for row, line := range glyph {
+			for column, dot := range line {
+				if dot != '#' {
+					continue
+				}
+				rect := image.Rect(x+column*scale, y+row*scale, x+(column+1)*scale, y+(row+1)*scale)
+				c.FillRect(rect, col)
+			}
+		}
+		x += (glyphWidth + 1) * scale
+	}
+}
+
+// DrawLabel draws the text over a white box, so it stays readable on top of lines.
+func (c *Canvas) DrawLabel(x, y int, text string, scale int, col color.Color) {
+	size := TextSize(strings.TrimSpace(text), scale)
+	c.FillRect(image.Rect(x-scale, y-scale, x+size.X+scale, y+size.Y+scale), White)
+	c.DrawText(x, y, strings.TrimSpace(text), scale, col)
+}
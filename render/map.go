@@ -0,0 +1,186 @@
+package render
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+
+	"github.com/nolka/gooffroadmaster/track"
+)
+
+var segmentColors = []color.RGBA{
+	{220, 40, 40, 255},
+	{40, 80, 220, 255},
+	{230, 140, 0, 255},
+	{150, 40, 180, 255},
+	{0, 150, 150, 255},
+}
+
+type MapOptions struct {
+	Width  int
+	Height int
+	// Height of the elevation strip under the map, zero disables it
+	ProfileHeight int
+	Padding       int
+	LineWidth     float64
+}
+
+func DefaultMapOptions() MapOptions {
+	return MapOptions{
+		Width:         800,
+		Height:        600,
+		ProfileHeight: 120,
+		Padding:       30,
+		LineWidth:     3,
+	}
+}
+
+// projection maps coordinates to pixels using Web Mercator fitted into the given rectangle.
+type projection struct {
+	scale   float64
+	offsetX float64
+	offsetY float64
+	// metres in one pixel at the centre of the map
+	metresPerPixel float64
+}
+
+func mercatorX(lon float64) float64 {
+	return lon * math.Pi / 180
+}
+
+func mercatorY(lat float64) float64 {
+	lat = math.Max(-85, math.Min(85, lat))
+	return math.Log(math.Tan(math.Pi/4 + lat*math.Pi/360))
+}
+
+func newProjection(bounds track.Bounds, rect image.Rectangle) projection {
+	minX, maxX := mercatorX(bounds.MinLon), mercatorX(bounds.MaxLon)
+	minY, maxY := mercatorY(bounds.MinLat), mercatorY(bounds.MaxLat)
+	// Don't zoom into a single point endlessly
+	const minSpan = 1e-5
+	spanX := math.Max(maxX-minX, minSpan)
+	spanY := math.Max(maxY-minY, minSpan)
+
+	p := projection{}
+	p.scale = math.Min(float64(rect.Dx())/spanX, float64(rect.Dy())/spanY)
+	p.offsetX = float64(rect.Min.X) + (float64(rect.Dx())-(maxX-minX)*p.scale)/2 - minX*p.scale
+	p.offsetY = float64(rect.Min.Y) + (float64(rect.Dy())-(maxY-minY)*p.scale)/2 + maxY*p.scale
+
+	centerLat := (bounds.MinLat + bounds.MaxLat) / 2
+	oneDegree := track.Distance(track.Point{Lat: centerLat, Lon: 0}, track.Point{Lat: centerLat, Lon: 1})
+	p.metresPerPixel = oneDegree / (mercatorX(1) * p.scale)
+	return p
+}
+
+func (p projection) project(pt track.Point) (float64, float64) {
+	return mercatorX(pt.Lon)*p.scale + p.offsetX, p.offsetY - mercatorY(pt.Lat)*p.scale
+}
+
+// niceDistance rounds metres down to 1, 2 or 5 multiplied by a power of ten.
+func niceDistance(metres float64) float64 {
+	if metres <= 0 {
+		return 1
+	}
+	pow := math.Pow(10, math.Floor(math.Log10(metres)))
+	for _, m := range []float64{5, 2, 1} {
+		if m*pow <= metres {
+			return m * pow
+		}
+	}
+	return pow
+}
+
+func FormatDistance(metres float64) string {
+	if metres >= 1000 {
+		return fmt.Sprintf("%g km", metres/1000)
+	}
+	return fmt.Sprintf("%g m", metres)
+}
+
+func allPoints(segments []track.Segment) []track.Point {
+	var points []track.Point
+	for _, s := range segments {
+		points = append(points, s.Points...)
+	}
+	return points
+}
+
+// RenderMap draws track segments over a metric grid with start and finish
+// markers, a scale bar and, if the track has altitudes, an elevation strip.
+func RenderMap(segments []track.Segment, options MapOptions) (*Canvas, error) {
+	points := allPoints(segments)
+	if len(points) == 0 {
+		return nil, errors.New("Track has no points")
+	}
+
+	c := NewCanvas(options.Width, options.Height, White)
+	mapRect := image.Rect(0, 0, options.Width, options.Height)
+	series := elevationSeries(segments)
+	if options.ProfileHeight > 0 && len(series) > 1 {
+		mapRect.Max.Y -= options.ProfileHeight
+		profileRect := image.Rect(0, mapRect.Max.Y, options.Width, options.Height)
+		c.DrawHorizontalLine(0, options.Width, mapRect.Max.Y, Gray)
+		drawProfileStrip(c, profileRect.Inset(options.Padding/3), series)
+	}
+
+	inner := mapRect.Inset(options.Padding)
+	proj := newProjection(track.GetBounds(points), inner)
+
+	gridStep := niceDistance(proj.metresPerPixel * float64(inner.Dx()) / 4)
+	drawGrid(c, mapRect, proj, gridStep/proj.metresPerPixel)
+
+	for i, s := range segments {
+		col := segmentColors[i%len(segmentColors)]
+		for j := 1; j < len(s.Points); j++ {
+			x0, y0 := proj.project(s.Points[j-1])
+			x1, y1 := proj.project(s.Points[j])
+			c.DrawLine(x0, y0, x1, y1, options.LineWidth, col)
+		}
+		if len(s.Points) == 1 {
+			x, y := proj.project(s.Points[0])
+			c.FillCircle(x, y, options.LineWidth, col)
+		}
+	}
+
+	drawMarker(c, proj, points[0], Green)
+	drawMarker(c, proj, points[len(points)-1], Red)
+	drawScaleBar(c, mapRect, gridStep, gridStep/proj.metresPerPixel)
+
+	return c, nil
+}
+
+func drawGrid(c *Canvas, rect image.Rectangle, proj projection, step float64) {
+	if step < 4 {
+		return
+	}
+	// Lines are aligned to the projection origin, so they don't move with the track
+	startX := math.Mod(proj.offsetX, step)
+	for x := startX; x < float64(rect.Max.X); x += step {
+		c.DrawVerticalLine(int(x), rect.Min.Y, rect.Max.Y-1, LightGray)
+	}
+	startY := math.Mod(proj.offsetY, step)
+	for y := startY; y < float64(rect.Max.Y); y += step {
+		c.DrawHorizontalLine(rect.Min.X, rect.Max.X-1, int(y), LightGray)
+	}
+}
+
+func drawMarker(c *Canvas, proj projection, pt track.Point, col color.Color) {
+	x, y := proj.project(pt)
+	c.FillCircle(x, y, 8, White)
+	c.FillCircle(x, y, 6, col)
+}
+
+func drawScaleBar(c *Canvas, rect image.Rectangle, metres, pixels float64) {
+	const scale = 2
+	x0 := rect.Min.X + 10
+	y := rect.Max.Y - 12
+	x1 := x0 + int(pixels)
+
+	c.FillRect(image.Rect(x0-4, y-TextSize("0", scale).Y-10, x1+4, y+5), White)
+	c.FillRect(image.Rect(x0, y-1, x1, y+2), Black)
+	c.DrawVerticalLine(x0, y-5, y+1, Black)
+	c.DrawVerticalLine(x1, y-5, y+1, Black)
+	c.DrawText(x0, y-TextSize("0", scale).Y-6, FormatDistance(metres), scale, Black)
+}
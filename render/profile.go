@@ -0,0 +1,248 @@
+package render
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+
+	"github.com/nolka/gooffroadmaster/track"
+)
+
+var profileFill = color.RGBA{170, 200, 240, 255}
+
+type profilePoint struct {
+	Distance float64
+	Ele      float64
+}
+
+// elevationSeries returns altitude against distance from the track start.
+// Gaps between segments don't add to the distance.
+func elevationSeries(segments []track.Segment) []profilePoint {
+	var series []profilePoint
+	distance := 0.0
+	for _, s := range segments {
+		for i, p := range s.Points {
+			if i > 0 {
+				distance += track.Distance(s.Points[i-1], p)
+			}
+			if p.HasEle {
+				series = append(series, profilePoint{Distance: distance, Ele: p.Ele})
+			}
+		}
+	}
+	return series
+}
+
+func seriesRange(series []profilePoint) (minEle, maxEle float64) {
+	minEle, maxEle = math.Inf(1), math.Inf(-1)
+	for _, p := range series {
+		minEle = math.Min(minEle, p.Ele)
+		maxEle = math.Max(maxEle, p.Ele)
+	}
+	if maxEle-minEle < 1 {
+		maxEle = minEle + 1
+	}
+	return minEle, maxEle
+}
+
+// drawProfileStrip draws a compact elevation area chart with min and max labels.
+func drawProfileStrip(c *Canvas, rect image.Rectangle, series []profilePoint) {
+	total := series[len(series)-1].Distance
+	if total <= 0 {
+		return
+	}
+	minEle, maxEle := seriesRange(series)
+
+	toY := func(ele float64) int {
+		return rect.Max.Y - int((ele-minEle)/(maxEle-minEle)*float64(rect.Dy()))
+	}
+
+	// For every pixel column take the last point falling into it
+	j := 0
+	prevY := toY(series[0].Ele)
+	for x := rect.Min.X; x < rect.Max.X; x++ {
+		d := float64(x-rect.Min.X) / float64(rect.Dx()) * total
+		for j < len(series)-1 && series[j+1].Distance <= d {
+			j++
+		}
+		y := toY(series[j].Ele)
+		c.DrawVerticalLine(x, y, rect.Max.Y, profileFill)
+		c.DrawVerticalLine(x, prevY, y, Blue)
+		prevY = y
+	}
+
+	c.DrawLabel(rect.Min.X+2, rect.Min.Y+2, fmt.Sprintf("%.0f m", maxEle), 2, Black)
+	c.DrawLabel(rect.Min.X+2, rect.Max.Y-TextSize("0", 2).Y-2, fmt.Sprintf("%.0f m", minEle), 2, Black)
+	label := FormatDistance(math.Round(total/100) * 100)
+	c.DrawLabel(rect.Max.X-TextSize(label, 2).X-2, rect.Max.Y-TextSize("0", 2).Y-2, label, 2, Black)
+}
+
+type ProfileOptions struct {
+	Width  int
+	Height int
+	// Distance in metres over which the grade is averaged
+	GradeWindow float64
+}
+
+func DefaultProfileOptions() ProfileOptions {
+	return ProfileOptions{
+		Width:       800,
+		Height:      400,
+		GradeWindow: 50,
+	}
+}
+
+// Grade colours, each used while absolute grade is below the limit in percents.
+var gradeColors = []struct {
+	Limit float64
+	Label string
+	Color color.RGBA
+}{
+	{3, "0-3%", color.RGBA{110, 190, 90, 255}},
+	{6, "3-6%", color.RGBA{235, 210, 60, 255}},
+	{10, "6-10%", color.RGBA{240, 140, 40, 255}},
+	{math.Inf(1), "10+%", color.RGBA{215, 50, 40, 255}},
+}
+
+func gradeColor(grade float64) color.RGBA {
+	grade = math.Abs(grade)
+	for _, g := range gradeColors {
+		if grade < g.Limit {
+			return g.Color
+		}
+	}
+	return gradeColors[len(gradeColors)-1].Color
+}
+
+// grades returns grade in percents for every series point, averaged over window metres around it.
+func grades(series []profilePoint, window float64) []float64 {
+	result := make([]float64, len(series))
+	from, to := 0, 0
+	for i, p := range series {
+		for from < i && p.Distance-series[from].Distance > window/2 {
+			from++
+		}
+		if to < i {
+			to = i
+		}
+		for to < len(series)-1 && series[to+1].Distance-p.Distance <= window/2 {
+			to++
+		}
+		dist := series[to].Distance - series[from].Distance
+		if dist > 0 {
+			result[i] = (series[to].Ele - series[from].Ele) / dist * 100
+		}
+	}
+	return result
+}
+
+// RenderProfile draws elevation against distance, coloured by grade, with
+// the highest and lowest points marked.
+func RenderProfile(segments []track.Segment, options ProfileOptions) (*Canvas, error) {
+	series := elevationSeries(segments)
+	if len(series) < 2 || series[len(series)-1].Distance <= 0 {
+		return nil, errors.New("Track has no elevation data")
+	}
+	total := series[len(series)-1].Distance
+	slopes := grades(series, options.GradeWindow)
+
+	const textScale = 2
+	textHeight := TextSize("0", textScale).Y
+	c := NewCanvas(options.Width, options.Height, White)
+	plot := image.Rect(60, 20, options.Width-20, options.Height-30-textHeight*2)
+
+	minEle, maxEle := seriesRange(series)
+	eleStep := niceDistance((maxEle - minEle) / 4)
+	minEle = math.Floor(minEle/eleStep) * eleStep
+	maxEle = math.Ceil(maxEle/eleStep) * eleStep
+	if maxEle <= minEle {
+		maxEle = minEle + eleStep
+	}
+
+	toX := func(d float64) float64 {
+		return float64(plot.Min.X) + d/total*float64(plot.Dx())
+	}
+	toY := func(ele float64) float64 {
+		return float64(plot.Max.Y) - (ele-minEle)/(maxEle-minEle)*float64(plot.Dy())
+	}
+
+	// Axes grid and labels
+	for ele := minEle; ele <= maxEle+eleStep/2; ele += eleStep {
+		y := int(toY(ele))
+		c.DrawHorizontalLine(plot.Min.X, plot.Max.X, y, LightGray)
+		label := fmt.Sprintf("%.0f", ele)
+		c.DrawText(plot.Min.X-8-TextSize(label, textScale).X, y-textHeight/2, label, textScale, Black)
+	}
+	distStep := niceDistance(total / 5)
+	for d := 0.0; d <= total; d += distStep {
+		x := int(toX(d))
+		c.DrawVerticalLine(x, plot.Min.Y, plot.Max.Y, LightGray)
+		label := FormatDistance(d)
+		c.DrawText(x-TextSize(label, textScale).X/2, plot.Max.Y+8, label, textScale, Black)
+	}
+
+	// Area under the line, coloured by grade of the nearest point
+	j := 0
+	for x := plot.Min.X; x <= plot.Max.X; x++ {
+		d := float64(x-plot.Min.X) / float64(plot.Dx()) * total
+		for j < len(series)-1 && series[j+1].Distance <= d {
+			j++
+		}
+		ele := series[j].Ele
+		if j < len(series)-1 {
+			span := series[j+1].Distance - series[j].Distance
+			if span > 0 {
+				ele += (series[j+1].Ele - series[j].Ele) * (d - series[j].Distance) / span
+			}
+		}
+		c.DrawVerticalLine(x, int(toY(ele)), plot.Max.Y, gradeColor(slopes[j]))
+	}
+	for i := 1; i < len(series); i++ {
+		c.DrawLine(toX(series[i-1].Distance), toY(series[i-1].Ele), toX(series[i].Distance), toY(series[i].Ele), 1.5, Black)
+	}
+	c.DrawHorizontalLine(plot.Min.X, plot.Max.X, plot.Max.Y, Black)
+	c.DrawVerticalLine(plot.Min.X, plot.Min.Y, plot.Max.Y, Black)
+
+	highest, lowest := 0, 0
+	for i, p := range series {
+		if p.Ele > series[highest].Ele {
+			highest = i
+		}
+		if p.Ele < series[lowest].Ele {
+			lowest = i
+		}
+	}
+	annotateProfilePoint(c, plot, toX(series[highest].Distance), toY(series[highest].Ele), series[highest].Ele, true)
+	annotateProfilePoint(c, plot, toX(series[lowest].Distance), toY(series[lowest].Ele), series[lowest].Ele, false)
+
+	// Legend
+	x := plot.Min.X
+	y := options.Height - textHeight - 6
+	for _, g := range gradeColors {
+		c.FillRect(image.Rect(x, y, x+textHeight*2, y+textHeight), g.Color)
+		x += textHeight*2 + 6
+		c.DrawText(x, y, g.Label, textScale, Black)
+		x += TextSize(g.Label, textScale).X + 16
+	}
+
+	return c, nil
+}
+
+func annotateProfilePoint(c *Canvas, plot image.Rectangle, x, y, ele float64, above bool) {
+	const textScale = 2
+	c.FillCircle(x, y, 5, White)
+	c.FillCircle(x, y, 4, Black)
+
+	label := fmt.Sprintf("%.0f m", ele)
+	size := TextSize(label, textScale)
+	lx := int(x) - size.X/2
+	lx = int(math.Max(float64(plot.Min.X+2), math.Min(float64(lx), float64(plot.Max.X-size.X-2))))
+	ly := int(y) + 10
+	if above {
+		ly = int(y) - 10 - size.Y
+	}
+	ly = int(math.Max(float64(plot.Min.Y-size.Y/2), math.Min(float64(ly), float64(plot.Max.Y-size.Y-2))))
+	c.DrawLabel(lx, ly, label, textScale, Black)
+}
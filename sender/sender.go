@@ -0,0 +1,239 @@
+package sender
+
+import (
+	"log"
+	"regexp"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nolka/gooffroadmaster/mvc"
+	"gopkg.in/telegram-bot-api.v4"
+)
+
+// Telegram flood limits: about 30 messages per second in total, one message
+// per second in a private chat and 20 messages per minute in a group.
+const (
+	GlobalRate      = 30
+	PrivateChatRate = 1
+	GroupChatRate   = 20.0 / 60
+	chatBurst       = 3
+	maxRetries      = 3
+	statsInterval   = time.Minute
+)
+
+// Uploads report flood errors only in the description text
+var retryAfterPattern = regexp.MustCompile(`retry after (\d+)`)
+
+// Stats shows the sender load.
+type Stats struct {
+	// Results waiting to be sent
+	Queued int
+	// Chats with waiting results and the longest chat queue
+	Chats        int
+	MaxChatQueue int
+	Sent         int64
+	Retried      int64
+	Failed       int64
+}
+
+// Sender delivers results keeping to Telegram flood limits. Every chat has
+// its own queue drained by its own goroutine, so a throttled group chat
+// doesn't hold messages to other chats.
+type Sender struct {
+	bot     *tgbotapi.BotAPI
+	global  *TokenBucket
+	mu      sync.Mutex
+	queues  map[int64][]mvc.Result
+	buckets map[int64]*TokenBucket
+	sent    int64
+	retried int64
+	failed  int64
+}
+
+func NewSender(bot *tgbotapi.BotAPI) *Sender {
+	s := &Sender{}
+	s.bot = bot
+	s.global = NewTokenBucket(GlobalRate, GlobalRate)
+	s.queues = make(map[int64][]mvc.Result)
+	s.buckets = make(map[int64]*TokenBucket)
+	return s
+}
+
+// Run sends results from the channel until it is closed.
+func (s *Sender) Run(results chan mvc.Result) {
+	go s.report()
+	for result := range results {
+		s.push(result)
+	}
+}
+
+func (s *Sender) Stats() Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats := Stats{
+		Chats:   len(s.queues),
+		Sent:    atomic.LoadInt64(&s.sent),
+		Retried: atomic.LoadInt64(&s.retried),
+		Failed:  atomic.LoadInt64(&s.failed),
+	}
+	for _, q := range s.queues {
+		stats.Queued += len(q)
+		if len(q) > stats.MaxChatQueue {
+			stats.MaxChatQueue = len(q)
+		}
+	}
+	return stats
+}
+
+func (s *Sender) push(result mvc.Result) {
+	// Callback answers don't count against message limits and must be fast
+	if result.Answer != nil {
+		go s.deliver(result)
+		return
+	}
+
+	chatId := ChatID(result.Chattable)
+	s.mu.Lock()
+	q := s.queues[chatId]
+	s.queues[chatId] = append(q, result)
+	s.mu.Unlock()
+
+	// The queue is removed when it becomes empty, otherwise it is being drained already
+	if len(q) == 0 {
+		go s.drain(chatId)
+	}
+}
+
+func (s *Sender) drain(chatId int64) {
+	bucket := s.bucket(chatId)
+	for {
+		s.mu.Lock()
+		q := s.queues[chatId]
+		if len(q) == 0 {
+			delete(s.queues, chatId)
+			s.mu.Unlock()
+			return
+		}
+		result := q[0]
+		s.mu.Unlock()
+
+		bucket.Wait()
+		s.global.Wait()
+		s.deliver(result)
+
+		s.mu.Lock()
+		s.queues[chatId] = s.queues[chatId][1:]
+		s.mu.Unlock()
+	}
+}
+
+func (s *Sender) bucket(chatId int64) *TokenBucket {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b, ok := s.buckets[chatId]
+	if !ok {
+		switch {
+		case chatId > 0:
+			b = NewTokenBucket(PrivateChatRate, chatBurst)
+		case chatId < 0:
+			b = NewTokenBucket(GroupChatRate, chatBurst)
+		default:
+			// Chat is unknown, only the global limit applies
+			b = NewTokenBucket(GlobalRate, GlobalRate)
+		}
+		s.buckets[chatId] = b
+	}
+	return b
+}
+
+// deliver sends the result, waiting and retrying when Telegram asks to slow down.
+func (s *Sender) deliver(result mvc.Result) {
+	callback := result.Callback
+	result.Callback = nil
+
+	var message tgbotapi.Message
+	var err error
+	for attempt := 0; ; attempt++ {
+		message, err = result.Deliver(s.bot)
+		wait, limited := RetryAfter(err)
+		if !limited || attempt >= maxRetries {
+			break
+		}
+		log.Printf("Flood limit hit, retrying in %s\n", wait)
+		atomic.AddInt64(&s.retried, 1)
+		time.Sleep(wait)
+	}
+
+	if err != nil {
+		log.Printf("SEND ERR: %s\n", err)
+		atomic.AddInt64(&s.failed, 1)
+	} else {
+		atomic.AddInt64(&s.sent, 1)
+	}
+	if callback != nil {
+		callback(message, err)
+	}
+}
+
+// report logs the stats while there is some traffic and forgets idle chats.
+func (s *Sender) report() {
+	var last Stats
+	for range time.Tick(statsInterval) {
+		stats := s.Stats()
+		if stats != last {
+			log.Printf("Sender: queued %d in %d chats (max %d), sent %d, retried %d, failed %d\n",
+				stats.Queued, stats.Chats, stats.MaxChatQueue, stats.Sent, stats.Retried, stats.Failed)
+			last = stats
+		}
+
+		s.mu.Lock()
+		for chatId, b := range s.buckets {
+			if _, busy := s.queues[chatId]; !busy && b.Full() {
+				delete(s.buckets, chatId)
+			}
+		}
+		s.mu.Unlock()
+	}
+}
+
+// ChatID returns the chat the request goes to, zero if it can't be told.
+func ChatID(c tgbotapi.Chattable) int64 {
+	switch m := c.(type) {
+	case tgbotapi.MessageConfig:
+		return m.ChatID
+	case tgbotapi.DocumentConfig:
+		return m.ChatID
+	case tgbotapi.PhotoConfig:
+		return m.ChatID
+	case tgbotapi.ChatActionConfig:
+		return m.ChatID
+	case tgbotapi.EditMessageTextConfig:
+		return m.ChatID
+	case tgbotapi.EditMessageReplyMarkupConfig:
+		return m.ChatID
+	case tgbotapi.EditMessageCaptionConfig:
+		return m.ChatID
+	case tgbotapi.DeleteMessageConfig:
+		return m.ChatID
+	}
+	return 0
+}
+
+// RetryAfter tells whether the error is a flood limit error and how long to wait.
+func RetryAfter(err error) (time.Duration, bool) {
+	if err == nil {
+		return 0, false
+	}
+	if e, ok := err.(tgbotapi.Error); ok && e.RetryAfter > 0 {
+		return time.Duration(e.RetryAfter) * time.Second, true
+	}
+	if m := retryAfterPattern.FindStringSubmatch(err.Error()); m != nil {
+		seconds, _ := strconv.Atoi(m[1])
+		return time.Duration(seconds) * time.Second, true
+	}
+	return 0, false
+}
@@ -0,0 +1,62 @@
+package track
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// Windows-1251 is the code page of files written by Russian versions of
+// Windows programs like OziExplorer. Bytes below 0x80 are ASCII, 0xC0-0xFF
+// are А-я in order.
+var cp1251High = [0x40]rune{
+	'Ђ', 'Ѓ', '‚', 'ѓ', '„', '…', '†', '‡', '€', '‰', 'Љ', '‹', 'Њ', 'Ќ', 'Ћ', 'Џ',
+	'ђ', '‘', '’', '“', '”', '•', '–', '—', utf8.RuneError, '™', 'љ', '›', 'њ', 'ќ', 'ћ', 'џ',
+	' ', 'Ў', 'ў', 'Ј', '¤', 'Ґ', '¦', '§', 'Ё', '©', 'Є', '«', '¬', '­', '®', 'Ї',
+	'°', '±', 'І', 'і', 'ґ', 'µ', '¶', '·', 'ё', '№', 'є', '»', 'ј', 'Ѕ', 'ѕ', 'ї',
+}
+
+var cp1251Bytes = func() map[rune]byte {
+	m := make(map[rune]byte)
+	for i, r := range cp1251High {
+		if r != utf8.RuneError {
+			m[r] = byte(0x80 + i)
+		}
+	}
+	return m
+}()
+
+func cp1251Decode(b []byte) string {
+	s := &strings.Builder{}
+	for _, c := range b {
+		switch {
+		case c < 0x80:
+			s.WriteByte(c)
+		case c < 0xC0:
+			s.WriteRune(cp1251High[c-0x80])
+		default:
+			s.WriteRune('А' + rune(c-0xC0))
+		}
+	}
+	return s.String()
+}
+
+// cp1251Encode returns the text as bytes of the code page, characters it
+// has no place for become "?".
+func cp1251Encode(text string) []byte {
+	b := make([]byte, 0, len(text))
+	for _, r := range text {
+		switch {
+		case r < 0x80:
+			b = append(b, byte(r))
+		case r >= 'А' && r <= 'я':
+			b = append(b, byte(0xC0+r-'А'))
+		default:
+			c, ok := cp1251Bytes[r]
+			if !ok {
+				c = '?'
+			}
+			b = append(b, c)
+		}
+	}
+	return b
+}
@@ -0,0 +1,66 @@
+package track
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+)
+
+var ErrEmptyTrack = errors.New("Track has no segments and waypoints")
+
+// ParseError is returned when a track file is malformed. Line is 1-based and
+// is zero when the position is unknown.
+type ParseError struct {
+	Line int
+	Err  error
+}
+
+func (e *ParseError) Error() string {
+	if e.Line > 0 {
+		return fmt.Sprintf("line %d: %s", e.Line, e.Err)
+	}
+	return e.Err.Error()
+}
+
+type UnsupportedFormatError struct {
+	Ext string
+}
+
+func (e *UnsupportedFormatError) Error() string {
+	return fmt.Sprintf("Unsupported track format: %s", e.Ext)
+}
+
+func lineError(line int, err error) error {
+	if _, ok := err.(*ParseError); ok {
+		return err
+	}
+	return &ParseError{Line: line, Err: err}
+}
+
+// asParseError converts decoder errors to ParseError, finding out the line
+// from the content where the decoder only knows the offset.
+func asParseError(err error, content []byte) *ParseError {
+	switch e := err.(type) {
+	case *ParseError:
+		return e
+	case *xml.SyntaxError:
+		return &ParseError{Line: e.Line, Err: err}
+	case *json.SyntaxError:
+		return &ParseError{Line: offsetToLine(content, e.Offset), Err: err}
+	case *json.UnmarshalTypeError:
+		return &ParseError{Line: offsetToLine(content, e.Offset), Err: err}
+	}
+	return &ParseError{Err: err}
+}
+
+func offsetToLine(content []byte, offset int64) int {
+	if content == nil || offset <= 0 {
+		return 0
+	}
+	if offset > int64(len(content)) {
+		offset = int64(len(content))
+	}
+	return bytes.Count(content[:offset], []byte("\n")) + 1
+}
@@ -0,0 +1,188 @@
+package track
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+// GeoJsonFormat reads LineString and MultiLineString features as segments and
+// Point features as waypoints. Segment point times are kept in the "coordTimes"
+// property, as togeojson and most other tools do.
+type GeoJsonFormat struct {
+	Data
+}
+
+type geoJsonCollection struct {
+	Type     string           `json:"type"`
+	Features []geoJsonFeature `json:"features"`
+}
+
+type geoJsonFeature struct {
+	Type       string                 `json:"type"`
+	Properties map[string]interface{} `json:"properties"`
+	Geometry   *geoJsonGeometry       `json:"geometry"`
+}
+
+type geoJsonGeometry struct {
+	Type        string          `json:"type"`
+	Coordinates json.RawMessage `json:"coordinates"`
+}
+
+func (g *GeoJsonFormat) Read(r io.Reader) error {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	doc := geoJsonCollection{}
+	if err = json.Unmarshal(b, &doc); err != nil {
+		return err
+	}
+
+	switch doc.Type {
+	case "FeatureCollection":
+		for i, feature := range doc.Features {
+			if err = g.addFeature(feature); err != nil {
+				return fmt.Errorf("Feature %d: %s", i, err)
+			}
+		}
+	case "Feature":
+		feature := geoJsonFeature{}
+		if err = json.Unmarshal(b, &feature); err != nil {
+			return err
+		}
+		return g.addFeature(feature)
+	default:
+		return fmt.Errorf("Unsupported GeoJSON root type: %s", doc.Type)
+	}
+	return nil
+}
+
+func (g *GeoJsonFormat) addFeature(f geoJsonFeature) error {
+	if f.Geometry == nil {
+		return nil
+	}
+	name, _ := f.Properties["name"].(string)
+
+	switch f.Geometry.Type {
+	case "Point":
+		var coord []float64
+		if err := json.Unmarshal(f.Geometry.Coordinates, &coord); err != nil {
+			return err
+		}
+		point, err := geoJsonPoint(coord)
+		if err != nil {
+			return err
+		}
+		if t, ok := f.Properties["time"].(string); ok {
+			point.Time = parseTime(t)
+		}
+		desc, _ := f.Properties["desc"].(string)
+		g.Waypoints = append(g.Waypoints, Waypoint{Point: point, Name: name, Desc: desc})
+	case "LineString":
+		var coords [][]float64
+		if err := json.Unmarshal(f.Geometry.Coordinates, &coords); err != nil {
+			return err
+		}
+		times, _ := f.Properties["coordTimes"].([]interface{})
+		return g.addLine(name, coords, times)
+	case "MultiLineString":
+		var lines [][][]float64
+		if err := json.Unmarshal(f.Geometry.Coordinates, &lines); err != nil {
+			return err
+		}
+		times, _ := f.Properties["coordTimes"].([]interface{})
+		for i, coords := range lines {
+			var lineTimes []interface{}
+			if i < len(times) {
+				lineTimes, _ = times[i].([]interface{})
+			}
+			if err := g.addLine(name, coords, lineTimes); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (g *GeoJsonFormat) addLine(name string, coords [][]float64, times []interface{}) error {
+	s := Segment{Name: name}
+	for i, coord := range coords {
+		point, err := geoJsonPoint(coord)
+		if err != nil {
+			return err
+		}
+		if i < len(times) {
+			if t, ok := times[i].(string); ok {
+				point.Time = parseTime(t)
+			}
+		}
+		s.Points = append(s.Points, point)
+	}
+	g.appendSegment(s)
+	return nil
+}
+
+func geoJsonPoint(coord []float64) (Point, error) {
+	if len(coord) < 2 {
+		return Point{}, fmt.Errorf("Invalid position: %v", coord)
+	}
+	point := Point{Lon: coord[0], Lat: coord[1]}
+	if len(coord) > 2 {
+		point.Ele = coord[2]
+		point.HasEle = true
+	}
+	return point, nil
+}
+
+func geoJsonCoord(p Point) []float64 {
+	if p.HasEle {
+		return []float64{p.Lon, p.Lat, p.Ele}
+	}
+	return []float64{p.Lon, p.Lat}
+}
+
+func (g *GeoJsonFormat) Write(w io.Writer) error {
+	doc := geoJsonCollection{Type: "FeatureCollection", Features: []geoJsonFeature{}}
+
+	for _, wpt := range g.Waypoints {
+		properties := map[string]interface{}{"name": wpt.Name}
+		if wpt.Desc != "" {
+			properties["desc"] = wpt.Desc
+		}
+		if !wpt.Time.IsZero() {
+			properties["time"] = formatTime(wpt.Time)
+		}
+		coords, _ := json.Marshal(geoJsonCoord(wpt.Point))
+		doc.Features = append(doc.Features, geoJsonFeature{
+			Type:       "Feature",
+			Properties: properties,
+			Geometry:   &geoJsonGeometry{Type: "Point", Coordinates: coords},
+		})
+	}
+
+	for _, seg := range g.Segments {
+		var line [][]float64
+		var times []string
+		for _, p := range seg.Points {
+			line = append(line, geoJsonCoord(p))
+			times = append(times, formatTime(p.Time))
+		}
+		properties := map[string]interface{}{"name": seg.Name}
+		if hasTimestamps(seg) {
+			properties["coordTimes"] = times
+		}
+		coords, _ := json.Marshal(line)
+		doc.Features = append(doc.Features, geoJsonFeature{
+			Type:       "Feature",
+			Properties: properties,
+			Geometry:   &geoJsonGeometry{Type: "LineString", Coordinates: coords},
+		})
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(doc)
+}
@@ -0,0 +1,127 @@
+package track
+
+import (
+	"encoding/xml"
+	"io"
+	"strconv"
+)
+
+const (
+	gpx10Namespace = "http://www.topografix.com/GPX/1/0"
+	gpx11Namespace = "http://www.topografix.com/GPX/1/1"
+)
+
+// GpxFormat reads GPX 1.0 and 1.1 documents. Version selects which one is
+// written, 1.1 is used when it is empty.
+type GpxFormat struct {
+	Data
+	Version string
+}
+
+type gpxDocument struct {
+	XMLName   xml.Name   `xml:"gpx"`
+	Version   string     `xml:"version,attr"`
+	Creator   string     `xml:"creator,attr"`
+	Xmlns     string     `xml:"xmlns,attr,omitempty"`
+	Waypoints []gpxPoint `xml:"wpt"`
+	Routes    []gpxRoute `xml:"rte"`
+	Tracks    []gpxTrack `xml:"trk"`
+}
+
+type gpxRoute struct {
+	Name   string     `xml:"name,omitempty"`
+	Points []gpxPoint `xml:"rtept"`
+}
+
+type gpxTrack struct {
+	Name     string       `xml:"name,omitempty"`
+	Segments []gpxSegment `xml:"trkseg"`
+}
+
+type gpxSegment struct {
+	Points []gpxPoint `xml:"trkpt"`
+}
+
+type gpxPoint struct {
+	Lat  float64 `xml:"lat,attr"`
+	Lon  float64 `xml:"lon,attr"`
+	Ele  string  `xml:"ele,omitempty"`
+	Time string  `xml:"time,omitempty"`
+	Name string  `xml:"name,omitempty"`
+	Desc string  `xml:"desc,omitempty"`
+}
+
+func (p gpxPoint) toPoint() Point {
+	point := Point{Lat: p.Lat, Lon: p.Lon, Time: parseTime(p.Time)}
+	if ele, err := strconv.ParseFloat(p.Ele, 64); err == nil {
+		point.Ele = ele
+		point.HasEle = true
+	}
+	return point
+}
+
+func newGpxPoint(p Point) gpxPoint {
+	result := gpxPoint{Lat: p.Lat, Lon: p.Lon, Time: formatTime(p.Time)}
+	if p.HasEle {
+		result.Ele = strconv.FormatFloat(p.Ele, 'f', 1, 64)
+	}
+	return result
+}
+
+func (g *GpxFormat) Read(r io.Reader) error {
+	doc := gpxDocument{}
+	if err := xml.NewDecoder(r).Decode(&doc); err != nil {
+		return err
+	}
+	g.Version = doc.Version
+
+	for _, w := range doc.Waypoints {
+		g.Waypoints = append(g.Waypoints, Waypoint{Point: w.toPoint(), Name: w.Name, Desc: w.Desc})
+	}
+	for _, trk := range doc.Tracks {
+		for _, seg := range trk.Segments {
+			s := Segment{Name: trk.Name}
+			for _, p := range seg.Points {
+				s.Points = append(s.Points, p.toPoint())
+			}
+			g.appendSegment(s)
+		}
+	}
+	for _, rte := range doc.Routes {
+		s := Segment{Name: rte.Name}
+		for _, p := range rte.Points {
+			s.Points = append(s.Points, p.toPoint())
+		}
+		g.appendSegment(s)
+	}
+	return nil
+}
+
+func (g *GpxFormat) Write(w io.Writer) error {
+	doc := gpxDocument{Version: "1.1", Creator: "gooffroadmaster", Xmlns: gpx11Namespace}
+	if g.Version == "1.0" {
+		doc.Version = "1.0"
+		doc.Xmlns = gpx10Namespace
+	}
+
+	for _, wpt := range g.Waypoints {
+		p := newGpxPoint(wpt.Point)
+		p.Name = wpt.Name
+		p.Desc = wpt.Desc
+		doc.Waypoints = append(doc.Waypoints, p)
+	}
+	for _, seg := range g.Segments {
+		trk := gpxTrack{Name: seg.Name, Segments: []gpxSegment{{}}}
+		for _, p := range seg.Points {
+			trk.Segments[0].Points = append(trk.Segments[0].Points, newGpxPoint(p))
+		}
+		doc.Tracks = append(doc.Tracks, trk)
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	return enc.Encode(doc)
+}
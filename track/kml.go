@@ -0,0 +1,252 @@
+package track
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const (
+	kmlNamespace   = "http://www.opengis.net/kml/2.2"
+	kmlGxNamespace = "http://www.google.com/kml/ext/2.2"
+)
+
+// KmlFormat reads LineString, MultiGeometry and gx:Track placemarks as segments
+// and Point placemarks as waypoints. Segments with timestamps are written as
+// gx:Track so the time is kept.
+type KmlFormat struct {
+	Data
+	Name string
+}
+
+type kmlPlacemark struct {
+	Name          string          `xml:"name"`
+	Description   string          `xml:"description"`
+	Point         *kmlCoordinates `xml:"Point"`
+	LineString    *kmlCoordinates `xml:"LineString"`
+	MultiGeometry *struct {
+		LineStrings []kmlCoordinates `xml:"LineString"`
+		Tracks      []kmlTrack       `xml:"Track"`
+	} `xml:"MultiGeometry"`
+	Track      *kmlTrack `xml:"Track"`
+	MultiTrack *struct {
+		Tracks []kmlTrack `xml:"Track"`
+	} `xml:"MultiTrack"`
+}
+
+type kmlCoordinates struct {
+	Coordinates string `xml:"coordinates"`
+}
+
+type kmlTrack struct {
+	When  []string `xml:"when"`
+	Coord []string `xml:"coord"`
+}
+
+func (k *KmlFormat) Read(r io.Reader) error {
+	dec := xml.NewDecoder(r)
+	var parents []string
+	for {
+		token, err := dec.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		switch t := token.(type) {
+		case xml.EndElement:
+			if len(parents) > 0 {
+				parents = parents[:len(parents)-1]
+			}
+		case xml.StartElement:
+			parent := ""
+			if len(parents) > 0 {
+				parent = parents[len(parents)-1]
+			}
+			switch {
+			case t.Name.Local == "Placemark":
+				line, _ := dec.InputPos()
+				p := kmlPlacemark{}
+				if err = dec.DecodeElement(&p, &t); err != nil {
+					return err
+				}
+				if err = k.addPlacemark(p); err != nil {
+					return lineError(line, err)
+				}
+			case t.Name.Local == "name" && parent == "Document" && k.Name == "":
+				if err = dec.DecodeElement(&k.Name, &t); err != nil {
+					return err
+				}
+				k.Name = strings.TrimSpace(k.Name)
+			default:
+				parents = append(parents, t.Name.Local)
+			}
+		}
+	}
+}
+
+func (k *KmlFormat) addPlacemark(p kmlPlacemark) error {
+	name := strings.TrimSpace(p.Name)
+
+	if p.Point != nil {
+		points, err := parseKmlCoordinates(p.Point.Coordinates)
+		if err != nil {
+			return err
+		}
+		if len(points) > 0 {
+			k.Waypoints = append(k.Waypoints, Waypoint{Point: points[0], Name: name, Desc: strings.TrimSpace(p.Description)})
+		}
+	}
+
+	var lines []kmlCoordinates
+	var tracks []kmlTrack
+	if p.LineString != nil {
+		lines = append(lines, *p.LineString)
+	}
+	if p.MultiGeometry != nil {
+		lines = append(lines, p.MultiGeometry.LineStrings...)
+		tracks = append(tracks, p.MultiGeometry.Tracks...)
+	}
+	if p.Track != nil {
+		tracks = append(tracks, *p.Track)
+	}
+	if p.MultiTrack != nil {
+		tracks = append(tracks, p.MultiTrack.Tracks...)
+	}
+
+	for _, line := range lines {
+		points, err := parseKmlCoordinates(line.Coordinates)
+		if err != nil {
+			return err
+		}
+		k.appendSegment(Segment{Name: name, Points: points})
+	}
+	for _, trk := range tracks {
+		s := Segment{Name: name}
+		for i, coord := range trk.Coord {
+			point, err := parseKmlCoordinate(strings.Fields(coord))
+			if err != nil {
+				return err
+			}
+			if i < len(trk.When) {
+				point.Time = parseTime(trk.When[i])
+			}
+			s.Points = append(s.Points, point)
+		}
+		k.appendSegment(s)
+	}
+	return nil
+}
+
+// parseKmlCoordinates parses a "lon,lat[,alt] lon,lat[,alt] ..." list.
+func parseKmlCoordinates(s string) ([]Point, error) {
+	var points []Point
+	for _, tuple := range strings.Fields(s) {
+		point, err := parseKmlCoordinate(strings.Split(tuple, ","))
+		if err != nil {
+			return nil, err
+		}
+		points = append(points, point)
+	}
+	return points, nil
+}
+
+func parseKmlCoordinate(parts []string) (Point, error) {
+	point := Point{}
+	if len(parts) < 2 {
+		return point, fmt.Errorf("Invalid KML coordinate: %s", strings.Join(parts, ","))
+	}
+
+	var err error
+	if point.Lon, err = strconv.ParseFloat(parts[0], 64); err != nil {
+		return point, err
+	}
+	if point.Lat, err = strconv.ParseFloat(parts[1], 64); err != nil {
+		return point, err
+	}
+	if len(parts) > 2 {
+		if point.Ele, err = strconv.ParseFloat(parts[2], 64); err != nil {
+			return point, err
+		}
+		point.HasEle = true
+	}
+	return point, nil
+}
+
+func formatKmlCoordinate(p Point, separator string) string {
+	parts := []string{
+		strconv.FormatFloat(p.Lon, 'f', 6, 64),
+		strconv.FormatFloat(p.Lat, 'f', 6, 64),
+	}
+	if p.HasEle {
+		parts = append(parts, strconv.FormatFloat(p.Ele, 'f', 1, 64))
+	}
+	return strings.Join(parts, separator)
+}
+
+func hasTimestamps(s Segment) bool {
+	for _, p := range s.Points {
+		if p.Time.IsZero() {
+			return false
+		}
+	}
+	return len(s.Points) > 0
+}
+
+func (k *KmlFormat) Write(w io.Writer) error {
+	b := &strings.Builder{}
+	b.WriteString(xml.Header)
+	fmt.Fprintf(b, "<kml xmlns=\"%s\" xmlns:gx=\"%s\">\n<Document>\n", kmlNamespace, kmlGxNamespace)
+	if k.Name != "" {
+		fmt.Fprintf(b, "<name>%s</name>\n", escapeXml(k.Name))
+	}
+
+	for _, wpt := range k.Waypoints {
+		b.WriteString("<Placemark>\n")
+		fmt.Fprintf(b, "<name>%s</name>\n", escapeXml(wpt.Name))
+		if wpt.Desc != "" {
+			fmt.Fprintf(b, "<description>%s</description>\n", escapeXml(wpt.Desc))
+		}
+		fmt.Fprintf(b, "<Point><coordinates>%s</coordinates></Point>\n", formatKmlCoordinate(wpt.Point, ","))
+		b.WriteString("</Placemark>\n")
+	}
+
+	for _, seg := range k.Segments {
+		b.WriteString("<Placemark>\n")
+		if seg.Name != "" {
+			fmt.Fprintf(b, "<name>%s</name>\n", escapeXml(seg.Name))
+		}
+		if hasTimestamps(seg) {
+			b.WriteString("<gx:Track>\n<altitudeMode>clampToGround</altitudeMode>\n")
+			for _, p := range seg.Points {
+				fmt.Fprintf(b, "<when>%s</when>\n", formatTime(p.Time))
+			}
+			for _, p := range seg.Points {
+				fmt.Fprintf(b, "<gx:coord>%s</gx:coord>\n", formatKmlCoordinate(p, " "))
+			}
+			b.WriteString("</gx:Track>\n")
+		} else {
+			b.WriteString("<LineString>\n<tessellate>1</tessellate>\n<coordinates>\n")
+			for _, p := range seg.Points {
+				b.WriteString(formatKmlCoordinate(p, ","))
+				b.WriteString("\n")
+			}
+			b.WriteString("</coordinates>\n</LineString>\n")
+		}
+		b.WriteString("</Placemark>\n")
+	}
+
+	b.WriteString("</Document>\n</kml>\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func escapeXml(s string) string {
+	b := &strings.Builder{}
+	xml.EscapeText(b, []byte(s))
+	return b.String()
+}
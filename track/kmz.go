@@ -0,0 +1,53 @@
+package track
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"path"
+	"strings"
+)
+
+// KmzFormat is a zip archive holding a single doc.kml.
+type KmzFormat struct {
+	KmlFormat
+}
+
+func (k *KmzFormat) Read(r io.Reader) error {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	archive, err := zip.NewReader(bytes.NewReader(b), int64(len(b)))
+	if err != nil {
+		return err
+	}
+
+	for _, f := range archive.File {
+		if strings.ToLower(path.Ext(f.Name)) != ".kml" {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return err
+		}
+		defer rc.Close()
+		return k.KmlFormat.Read(rc)
+	}
+	return errors.New("KMZ archive contains no KML document")
+}
+
+func (k *KmzFormat) Write(w io.Writer) error {
+	archive := zip.NewWriter(w)
+	doc, err := archive.Create("doc.kml")
+	if err != nil {
+		return err
+	}
+	if err = k.KmlFormat.Write(doc); err != nil {
+		return err
+	}
+	return archive.Close()
+}
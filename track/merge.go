@@ -0,0 +1,40 @@
+package track
+
+import (
+	"sort"
+	"time"
+)
+
+// startTime returns the first known timestamp of the segment.
+func startTime(s Segment) time.Time {
+	for _, p := range s.Points {
+		if !p.Time.IsZero() {
+			return p.Time
+		}
+	}
+	return time.Time{}
+}
+
+// SortByTime orders segments by their start time. Segments without
+// timestamps keep their relative order and go last.
+func SortByTime(segments []Segment) []Segment {
+	result := make([]Segment, len(segments))
+	copy(result, segments)
+	sort.SliceStable(result, func(i, j int) bool {
+		a, b := startTime(result[i]), startTime(result[j])
+		if a.IsZero() || b.IsZero() {
+			return !a.IsZero() && b.IsZero()
+		}
+		return a.Before(b)
+	})
+	return result
+}
+
+// Concatenate joins segments into a single one, in the given order.
+func Concatenate(name string, segments []Segment) Segment {
+	result := Segment{Name: name}
+	for _, s := range segments {
+		result.Points = append(result.Points, s.Points...)
+	}
+	return result
+}
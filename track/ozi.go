@@ -0,0 +1,347 @@
+package track
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// OziExplorer files are CSV-like text with a fixed header, WGS 84 datum,
+// altitude in feet (-777 means unknown) and dates as days since 30.12.1899.
+
+const (
+	oziFeetInMeter  = 3.2808399
+	oziNoAltitude   = -777
+	oziLineEnd      = "\r\n"
+	oziDatum        = "WGS 84"
+	oziDefaultColor = 255
+)
+
+var oziEpoch = time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)
+
+func oziDaysToTime(s string) time.Time {
+	days, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil || days <= 0 {
+		return time.Time{}
+	}
+	return oziEpoch.Add(time.Duration(days * 24 * float64(time.Hour))).Round(time.Second)
+}
+
+func oziTimeToDays(t time.Time) float64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Sub(oziEpoch).Hours() / 24
+}
+
+func oziParseAltitude(s string, p *Point) {
+	feet, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil || feet == oziNoAltitude {
+		return
+	}
+	p.Ele = feet / oziFeetInMeter
+	p.HasEle = true
+}
+
+func oziFormatAltitude(p Point) string {
+	if !p.HasEle {
+		return strconv.Itoa(oziNoAltitude)
+	}
+	return strconv.FormatFloat(p.Ele*oziFeetInMeter, 'f', 1, 64)
+}
+
+func oziParseLatLon(lat, lon string) (Point, error) {
+	p := Point{}
+	var err error
+	if p.Lat, err = strconv.ParseFloat(strings.TrimSpace(lat), 64); err != nil {
+		return p, err
+	}
+	if p.Lon, err = strconv.ParseFloat(strings.TrimSpace(lon), 64); err != nil {
+		return p, err
+	}
+	return p, nil
+}
+
+// Commas can't be stored in Ozi text fields, Ozi itself writes byte 209 in
+// their place. Its files are in the Windows code page, in Windows-1251 the
+// byte is "С" too, so Ozi reads it back as a comma as well and so do we.
+const oziComma = 209
+
+// oziEscape returns the text as Windows-1251 bytes with commas escaped.
+func oziEscape(s string) string {
+	return string(bytes.Replace(cp1251Encode(s), []byte{','}, []byte{oziComma}, -1))
+}
+
+// oziDecodeField returns the text of a field. Files written by other programs
+// may be in UTF-8, they escape commas as "Ñ", which is character 209.
+func oziDecodeField(b []byte) string {
+	if utf8.Valid(b) {
+		return strings.Replace(string(b), string(rune(oziComma)), ",", -1)
+	}
+	return cp1251Decode(bytes.Replace(b, []byte{oziComma}, []byte{','}, -1))
+}
+
+type oziLine struct {
+	Num    int
+	Fields []string
+}
+
+// oziSplit returns decoded fields of the line. Fields are decoded after
+// splitting, so escaped commas are not taken for separators.
+func oziSplit(line []byte) []string {
+	var fields []string
+	for _, field := range bytes.Split(line, []byte{','}) {
+		fields = append(fields, oziDecodeField(field))
+	}
+	return fields
+}
+
+// oziReadLines returns header and data lines split to fields.
+func oziReadLines(r io.Reader, headerLines int) ([][]string, []oziLine, error) {
+	var header [][]string
+	var lines []oziLine
+	scanner := bufio.NewScanner(r)
+	num := 0
+	for scanner.Scan() {
+		num++
+		line := bytes.TrimRight(scanner.Bytes(), "\r")
+		if len(header) < headerLines {
+			header = append(header, oziSplit(line))
+			continue
+		}
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		lines = append(lines, oziLine{Num: num, Fields: oziSplit(line)})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+	if len(header) < headerLines || !strings.HasPrefix(header[0][0], "OziExplorer") {
+		return nil, nil, &ParseError{Line: 1, Err: errors.New("Not an OziExplorer file")}
+	}
+	return header, lines, nil
+}
+
+func oziCheckFields(line oziLine, count int) error {
+	if len(line.Fields) < count {
+		return lineError(line.Num, fmt.Errorf("expected at least %d fields, got %d", count, len(line.Fields)))
+	}
+	return nil
+}
+
+// PltFormat is OziExplorer track file. Points having the break flag set
+// start a new segment.
+type PltFormat struct {
+	Data
+}
+
+const pltHeaderLines = 6
+
+func (p *PltFormat) Read(r io.Reader) error {
+	header, lines, err := oziReadLines(r, pltHeaderLines)
+	if err != nil {
+		return err
+	}
+
+	name := ""
+	if fields := header[4]; len(fields) > 3 {
+		name = strings.TrimSpace(fields[3])
+	}
+
+	s := Segment{Name: name}
+	for _, line := range lines {
+		fields := line.Fields
+		if err = oziCheckFields(line, 3); err != nil {
+			return err
+		}
+		point, err := oziParseLatLon(fields[0], fields[1])
+		if err != nil {
+			return lineError(line.Num, err)
+		}
+		if len(fields) > 3 {
+			oziParseAltitude(fields[3], &point)
+		}
+		if len(fields) > 4 {
+			point.Time = oziDaysToTime(fields[4])
+		}
+		if strings.TrimSpace(fields[2]) == "1" && len(s.Points) > 0 {
+			p.appendSegment(s)
+			s = Segment{Name: name}
+		}
+		s.Points = append(s.Points, point)
+	}
+	p.appendSegment(s)
+	return nil
+}
+
+func (p *PltFormat) Write(w io.Writer) error {
+	name := "Track"
+	total := 0
+	for _, s := range p.Segments {
+		total += len(s.Points)
+		if s.Name != "" && name == "Track" {
+			name = s.Name
+		}
+	}
+
+	b := &strings.Builder{}
+	b.WriteString("OziExplorer Track Point File Version 2.1" + oziLineEnd)
+	b.WriteString(oziDatum + oziLineEnd)
+	b.WriteString("Altitude is in Feet" + oziLineEnd)
+	b.WriteString("Reserved 3" + oziLineEnd)
+	fmt.Fprintf(b, "0,2,%d,%s,0,0,2,%d%s", oziDefaultColor, oziEscape(name), oziDefaultColor, oziLineEnd)
+	fmt.Fprintf(b, "%d%s", total, oziLineEnd)
+
+	for _, s := range p.Segments {
+		for i, point := range s.Points {
+			brk := 0
+			if i == 0 {
+				brk = 1
+			}
+			date, clock := "", ""
+			if !point.Time.IsZero() {
+				date = point.Time.UTC().Format("02-Jan-06")
+				clock = point.Time.UTC().Format("15:04:05")
+			}
+			fmt.Fprintf(b, "%.6f,%.6f,%d,%s,%.7f,%s,%s%s",
+				point.Lat, point.Lon, brk, oziFormatAltitude(point), oziTimeToDays(point.Time), date, clock, oziLineEnd)
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// WptFormat is OziExplorer waypoint file.
+type WptFormat struct {
+	Data
+}
+
+const wptHeaderLines = 4
+
+func (p *WptFormat) Read(r io.Reader) error {
+	_, lines, err := oziReadLines(r, wptHeaderLines)
+	if err != nil {
+		return err
+	}
+
+	for _, line := range lines {
+		fields := line.Fields
+		if err = oziCheckFields(line, 4); err != nil {
+			return err
+		}
+		point, err := oziParseLatLon(fields[2], fields[3])
+		if err != nil {
+			return lineError(line.Num, err)
+		}
+		wpt := Waypoint{Name: strings.TrimSpace(fields[1])}
+		if len(fields) > 4 {
+			point.Time = oziDaysToTime(fields[4])
+		}
+		if len(fields) > 10 {
+			wpt.Desc = strings.TrimSpace(fields[10])
+		}
+		if len(fields) > 14 {
+			oziParseAltitude(fields[14], &point)
+		}
+		wpt.Point = point
+		p.Waypoints = append(p.Waypoints, wpt)
+	}
+	return nil
+}
+
+func (p *WptFormat) Write(w io.Writer) error {
+	b := &strings.Builder{}
+	b.WriteString("OziExplorer Waypoint File Version 1.1" + oziLineEnd)
+	b.WriteString(oziDatum + oziLineEnd)
+	b.WriteString("Reserved 2" + oziLineEnd)
+	b.WriteString("Reserved 3" + oziLineEnd)
+
+	for i, wpt := range p.Waypoints {
+		fmt.Fprintf(b, "%d,%s,%.6f,%.6f,%.7f,0,1,3,0,65535,%s,0,0,0,%s,6,0,17%s",
+			i+1, oziEscape(wpt.Name), wpt.Lat, wpt.Lon, oziTimeToDays(wpt.Time), oziEscape(wpt.Desc), oziFormatAltitude(wpt.Point), oziLineEnd)
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// RteFormat is OziExplorer route file. Every route becomes a segment.
+type RteFormat struct {
+	Data
+}
+
+const rteHeaderLines = 4
+
+func (p *RteFormat) Read(r io.Reader) error {
+	_, lines, err := oziReadLines(r, rteHeaderLines)
+	if err != nil {
+		return err
+	}
+
+	var s *Segment
+	for _, line := range lines {
+		fields := line.Fields
+		switch strings.TrimSpace(fields[0]) {
+		case "R":
+			if s != nil {
+				p.appendSegment(*s)
+			}
+			s = &Segment{}
+			if len(fields) > 2 {
+				s.Name = strings.TrimSpace(fields[2])
+			}
+		case "W":
+			if err = oziCheckFields(line, 7); err != nil {
+				return err
+			}
+			point, err := oziParseLatLon(fields[5], fields[6])
+			if err != nil {
+				return lineError(line.Num, err)
+			}
+			if len(fields) > 7 {
+				point.Time = oziDaysToTime(fields[7])
+			}
+			if s == nil {
+				s = &Segment{}
+			}
+			s.Points = append(s.Points, point)
+		}
+	}
+	if s != nil {
+		p.appendSegment(*s)
+	}
+	return nil
+}
+
+func (p *RteFormat) Write(w io.Writer) error {
+	b := &strings.Builder{}
+	b.WriteString("OziExplorer Route File Version 1.0" + oziLineEnd)
+	b.WriteString(oziDatum + oziLineEnd)
+	b.WriteString("Reserved 1" + oziLineEnd)
+	b.WriteString("Reserved 2" + oziLineEnd)
+
+	wptNum := 0
+	for i, s := range p.Segments {
+		name := s.Name
+		if name == "" {
+			name = fmt.Sprintf("Route %d", i+1)
+		}
+		fmt.Fprintf(b, "R,%d,%s,,%d%s", i+1, oziEscape(name), oziDefaultColor, oziLineEnd)
+		for j, point := range s.Points {
+			wptNum++
+			fmt.Fprintf(b, "W,%d,%d,%d,WP%03d,%.6f,%.6f,%.7f,0,1,3,0,65535,,0,0%s",
+				i+1, j+1, wptNum, wptNum, point.Lat, point.Lon, oziTimeToDays(point.Time), oziLineEnd)
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,128 @@
+package track
+
+import (
+	"math"
+	"sort"
+)
+
+// SimplifyOptions describes point thinning applied to every segment.
+// Zero values disable the corresponding step.
+type SimplifyOptions struct {
+	// Douglas-Peucker tolerance in metres
+	Tolerance float64
+	// Maximum number of points left in a segment
+	MaxPoints int
+}
+
+func (o SimplifyOptions) IsEmpty() bool {
+	return o.Tolerance <= 0 && o.MaxPoints <= 0
+}
+
+func (o SimplifyOptions) Apply(segments []Segment) []Segment {
+	if o.IsEmpty() {
+		return segments
+	}
+	result := make([]Segment, len(segments))
+	for i, s := range segments {
+		result[i] = Segment{Name: s.Name, Points: o.simplify(s.Points)}
+	}
+	return result
+}
+
+func (o SimplifyOptions) simplify(points []Point) []Point {
+	if len(points) < 3 {
+		return points
+	}
+	importance := pointImportance(points)
+
+	keep := make([]bool, len(points))
+	keep[0], keep[len(points)-1] = true, true
+	for i := 1; i < len(points)-1; i++ {
+		keep[i] = o.Tolerance <= 0 || importance[i] > o.Tolerance
+	}
+
+	if o.MaxPoints > 0 {
+		var indexes []int
+		for i := 1; i < len(points)-1; i++ {
+			if keep[i] {
+				indexes = append(indexes, i)
+			}
+		}
+		limit := o.MaxPoints - 2
+		if limit < 0 {
+			limit = 0
+		}
+		if len(indexes) > limit {
+			sort.SliceStable(indexes, func(a, b int) bool {
+				return importance[indexes[a]] > importance[indexes[b]]
+			})
+			for _, i := range indexes[limit:] {
+				keep[i] = false
+			}
+		}
+	}
+
+	var result []Point
+	for i, p := range points {
+		if keep[i] {
+			result = append(result, p)
+		}
+	}
+	return result
+}
+
+// pointImportance runs Douglas-Peucker without a tolerance and returns, for
+// every point, the deviation at which it was picked. Dropping points with
+// importance under a tolerance gives the classic Douglas-Peucker result,
+// keeping the N most important gives the best N-point approximation.
+func pointImportance(points []Point) []float64 {
+	importance := make([]float64, len(points))
+	importance[0], importance[len(points)-1] = math.Inf(1), math.Inf(1)
+
+	origin := points[0]
+	xy := make([][2]float64, len(points))
+	for i, p := range points {
+		xy[i] = localXY(origin, p)
+	}
+
+	type span struct{ from, to int }
+	stack := []span{{0, len(points) - 1}}
+	for len(stack) > 0 {
+		s := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if s.to-s.from < 2 {
+			continue
+		}
+
+		index, maxDist := s.from+1, -1.0
+		for i := s.from + 1; i < s.to; i++ {
+			d := segmentDistance(xy[i], xy[s.from], xy[s.to])
+			if d > maxDist {
+				index, maxDist = i, d
+			}
+		}
+		// A point can't be more important than the one which split its span
+		parent := math.Min(importance[s.from], importance[s.to])
+		importance[index] = math.Min(maxDist, parent)
+		stack = append(stack, span{s.from, index}, span{index, s.to})
+	}
+	return importance
+}
+
+// localXY projects the point to metres on a plane touching the Earth at origin.
+func localXY(origin, p Point) [2]float64 {
+	x := (p.Lon - origin.Lon) * math.Pi / 180 * earthRadius * math.Cos(origin.Lat*math.Pi/180)
+	y := (p.Lat - origin.Lat) * math.Pi / 180 * earthRadius
+	return [2]float64{x, y}
+}
+
+// segmentDistance returns distance from p to the segment a-b.
+func segmentDistance(p, a, b [2]float64) float64 {
+	dx, dy := b[0]-a[0], b[1]-a[1]
+	if dx == 0 && dy == 0 {
+		return math.Hypot(p[0]-a[0], p[1]-a[1])
+	}
+	k := ((p[0]-a[0])*dx + (p[1]-a[1])*dy) / (dx*dx + dy*dy)
+	k = math.Max(0, math.Min(1, k))
+	return math.Hypot(p[0]-a[0]-k*dx, p[1]-a[1]-k*dy)
+}
@@ -0,0 +1,81 @@
+package track
+
+import (
+	"math"
+	"testing"
+)
+
+// zigzag goes east along the equator, 0.001° (~111 m) a point, with points 1
+// and 3 off the line by ~11 m and ~56 m.
+func zigzag() []Point {
+	return []Point{
+		{Lat: 0, Lon: 0},
+		{Lat: 0.0001, Lon: 0.001},
+		{Lat: 0, Lon: 0.002},
+		{Lat: 0.0005, Lon: 0.003},
+		{Lat: 0, Lon: 0.004},
+	}
+}
+
+func TestSimplify(t *testing.T) {
+	tests := []struct {
+		name    string
+		options SimplifyOptions
+		points  []Point
+		want    []int
+	}{
+		{"empty options", SimplifyOptions{}, zigzag(), []int{0, 1, 2, 3, 4}},
+		{"two points", SimplifyOptions{Tolerance: 1000}, zigzag()[:2], []int{0, 1}},
+		{"small tolerance", SimplifyOptions{Tolerance: 5}, zigzag(), []int{0, 1, 2, 3, 4}},
+		{"drops small deviation", SimplifyOptions{Tolerance: 20}, zigzag(), []int{0, 2, 3, 4}},
+		{"keeps largest deviation", SimplifyOptions{Tolerance: 40}, zigzag(), []int{0, 3, 4}},
+		{"large tolerance", SimplifyOptions{Tolerance: 100}, zigzag(), []int{0, 4}},
+		{"max points", SimplifyOptions{MaxPoints: 4}, zigzag(), []int{0, 2, 3, 4}},
+		{"max points keeps most important", SimplifyOptions{MaxPoints: 3}, zigzag(), []int{0, 3, 4}},
+		{"max points keeps ends", SimplifyOptions{MaxPoints: 1}, zigzag(), []int{0, 4}},
+		{"both", SimplifyOptions{Tolerance: 5, MaxPoints: 3}, zigzag(), []int{0, 3, 4}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.options.Apply([]Segment{{Name: "zigzag", Points: tt.points}})
+			if len(got) != 1 || got[0].Name != "zigzag" {
+				t.Fatalf("Segments simplified as %v", got)
+			}
+			if len(got[0].Points) != len(tt.want) {
+				t.Fatalf("Got %d points, want %v", len(got[0].Points), tt.want)
+			}
+			for i, n := range tt.want {
+				if got[0].Points[i] != tt.points[n] {
+					t.Errorf("Point %d is %v, want point %d", i, got[0].Points[i], n)
+				}
+			}
+		})
+	}
+}
+
+func TestPointImportance(t *testing.T) {
+	tests := []struct {
+		name   string
+		points []Point
+		want   []float64
+	}{
+		{"straight line", []Point{{Lon: 0}, {Lon: 0.001}, {Lon: 0.002}}, []float64{0}},
+		{"zigzag", zigzag(), []float64{11.1, 36.6, 55.6}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := pointImportance(tt.points)
+			if len(got) != len(tt.points) {
+				t.Fatalf("Got %d values for %d points", len(got), len(tt.points))
+			}
+			if !math.IsInf(got[0], 1) || !math.IsInf(got[len(got)-1], 1) {
+				t.Errorf("Ends are not kept: %v", got)
+			}
+			for i, want := range tt.want {
+				if math.Abs(got[i+1]-want) > 0.5 {
+					t.Errorf("Point %d importance is %.1f, want %.1f", i+1, got[i+1], want)
+				}
+			}
+		})
+	}
+}
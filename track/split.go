@@ -0,0 +1,91 @@
+package track
+
+import (
+	"time"
+)
+
+// Part is one piece of a split track.
+type Part struct {
+	Segments []Segment
+}
+
+func (p Part) StartTime() time.Time {
+	for _, s := range p.Segments {
+		if t := startTime(s); !t.IsZero() {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// Cutter tells whether a new part starts between two consecutive points.
+type Cutter func(prev, cur Point) bool
+
+// ByDay cuts on calendar day boundaries in the given location.
+func ByDay(loc *time.Location) Cutter {
+	return func(prev, cur Point) bool {
+		if prev.Time.IsZero() || cur.Time.IsZero() {
+			return false
+		}
+		y1, m1, d1 := prev.Time.In(loc).Date()
+		y2, m2, d2 := cur.Time.In(loc).Date()
+		return y1 != y2 || m1 != m2 || d1 != d2
+	}
+}
+
+// ByGap cuts where no points were recorded for longer than gap.
+func ByGap(gap time.Duration) Cutter {
+	return func(prev, cur Point) bool {
+		if prev.Time.IsZero() || cur.Time.IsZero() {
+			return false
+		}
+		return cur.Time.Sub(prev.Time) > gap
+	}
+}
+
+// ByDistance cuts every given number of metres.
+func ByDistance(metres float64) Cutter {
+	passed := 0.0
+	return func(prev, cur Point) bool {
+		passed += Distance(prev, cur)
+		if passed >= metres {
+			passed = 0
+			return true
+		}
+		return false
+	}
+}
+
+// Split walks all points in order and starts a new part wherever cut says so.
+// Segment boundaries inside a part are kept.
+func Split(segments []Segment, cut Cutter) []Part {
+	var parts []Part
+	current := Part{}
+	var prev *Point
+
+	for _, s := range segments {
+		piece := Segment{Name: s.Name}
+		for i := range s.Points {
+			p := s.Points[i]
+			if prev != nil && cut(*prev, p) {
+				if len(piece.Points) > 0 {
+					current.Segments = append(current.Segments, piece)
+				}
+				if len(current.Segments) > 0 {
+					parts = append(parts, current)
+				}
+				current = Part{}
+				piece = Segment{Name: s.Name}
+			}
+			piece.Points = append(piece.Points, p)
+			prev = &s.Points[i]
+		}
+		if len(piece.Points) > 0 {
+			current.Segments = append(current.Segments, piece)
+		}
+	}
+	if len(current.Segments) > 0 {
+		parts = append(parts, current)
+	}
+	return parts
+}
@@ -0,0 +1,78 @@
+package track
+
+import (
+	"testing"
+	"time"
+)
+
+// line makes n points going east along the equator, 0.001° (~111 m) a point,
+// recorded at the given minutes after start.
+func line(n int, start time.Time, minutes ...int) []Point {
+	points := make([]Point, n)
+	for i := range points {
+		points[i].Lon = float64(i) * 0.001
+		if i < len(minutes) {
+			points[i].Time = start.Add(time.Duration(minutes[i]) * time.Minute)
+		}
+	}
+	return points
+}
+
+func TestSplit(t *testing.T) {
+	moscow := time.FixedZone("MSK", 3*60*60)
+	noon := time.Date(2020, 5, 17, 12, 0, 0, 0, time.UTC)
+	evening := time.Date(2020, 5, 17, 23, 58, 0, 0, time.UTC)
+	moscowEvening := time.Date(2020, 5, 17, 20, 58, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		segments []Segment
+		cut      Cutter
+		// Points in every segment of every part
+		want [][]int
+	}{
+		{"no points", nil, ByDistance(250), nil},
+		{"by distance", []Segment{{Points: line(7, noon)}}, ByDistance(250), [][]int{{3}, {3}, {1}}},
+		{"by distance keeps segments", []Segment{{Points: line(4, noon)}, {Points: line(7, noon)[4:]}}, ByDistance(250), [][]int{{3}, {1, 2}, {1}}},
+		{"shorter than distance", []Segment{{Points: line(4, noon)}, {Points: line(7, noon)[4:]}}, ByDistance(10000), [][]int{{4, 3}}},
+		{"by gap", []Segment{{Points: line(5, noon, 0, 1, 2, 30, 31)}}, ByGap(10 * time.Minute), [][]int{{3}, {2}}},
+		{"by gap without time", []Segment{{Points: line(5, noon)}}, ByGap(10 * time.Minute), [][]int{{5}}},
+		{"by day", []Segment{{Points: line(3, evening, 0, 1, 3)}}, ByDay(time.UTC), [][]int{{2}, {1}}},
+		{"by day in location", []Segment{{Points: line(3, moscowEvening, 0, 1, 3)}}, ByDay(moscow), [][]int{{2}, {1}}},
+		{"same day in UTC", []Segment{{Points: line(3, moscowEvening, 0, 1, 3)}}, ByDay(time.UTC), [][]int{{3}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parts := Split(tt.segments, tt.cut)
+			var got [][]int
+			for _, part := range parts {
+				var counts []int
+				for _, s := range part.Segments {
+					counts = append(counts, len(s.Points))
+				}
+				got = append(got, counts)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("Split as %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if len(got[i]) != len(tt.want[i]) {
+					t.Fatalf("Split as %v, want %v", got, tt.want)
+				}
+				for j := range got[i] {
+					if got[i][j] != tt.want[i][j] {
+						t.Fatalf("Split as %v, want %v", got, tt.want)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestPartStartTime(t *testing.T) {
+	start := time.Date(2020, 5, 17, 12, 0, 0, 0, time.UTC)
+	part := Part{Segments: []Segment{{Points: line(2, start)}, {Points: line(2, start, 5)}}}
+	if got := part.StartTime(); !got.Equal(start.Add(5 * time.Minute)) {
+		t.Errorf("Start time is %s", got)
+	}
+}
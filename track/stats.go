@@ -0,0 +1,114 @@
+package track
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	earthRadius = 6371000.0
+	// Slower movement is treated as standing still
+	movingSpeedThreshold = 1.0 / 3.6
+)
+
+type Bounds struct {
+	MinLat float64
+	MinLon float64
+	MaxLat float64
+	MaxLon float64
+}
+
+type SegmentStats struct {
+	Points        int
+	Distance      float64 // metres
+	MovingTime    time.Duration
+	StoppedTime   time.Duration
+	AvgSpeed      float64 // metres per second, over moving time
+	MaxSpeed      float64 // metres per second
+	ElevationGain float64
+	ElevationLoss float64
+	MinEle        float64
+	MaxEle        float64
+	HasTime       bool
+	HasEle        bool
+	Bounds        Bounds
+}
+
+// Distance returns great-circle distance between two points in metres.
+func Distance(a, b Point) float64 {
+	lat1 := a.Lat * math.Pi / 180
+	lat2 := b.Lat * math.Pi / 180
+	dLat := lat2 - lat1
+	dLon := (b.Lon - a.Lon) * math.Pi / 180
+
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadius * math.Asin(math.Min(1, math.Sqrt(h)))
+}
+
+func GetBounds(points []Point) Bounds {
+	if len(points) == 0 {
+		return Bounds{}
+	}
+	b := Bounds{MinLat: points[0].Lat, MaxLat: points[0].Lat, MinLon: points[0].Lon, MaxLon: points[0].Lon}
+	for _, p := range points[1:] {
+		b.MinLat = math.Min(b.MinLat, p.Lat)
+		b.MaxLat = math.Max(b.MaxLat, p.Lat)
+		b.MinLon = math.Min(b.MinLon, p.Lon)
+		b.MaxLon = math.Max(b.MaxLon, p.Lon)
+	}
+	return b
+}
+
+func CalculateStats(s Segment) SegmentStats {
+	stats := SegmentStats{Points: len(s.Points), Bounds: GetBounds(s.Points)}
+	if len(s.Points) == 0 {
+		return stats
+	}
+
+	for i, p := range s.Points {
+		if p.HasEle {
+			if !stats.HasEle {
+				stats.MinEle, stats.MaxEle = p.Ele, p.Ele
+				stats.HasEle = true
+			}
+			stats.MinEle = math.Min(stats.MinEle, p.Ele)
+			stats.MaxEle = math.Max(stats.MaxEle, p.Ele)
+		}
+		if i == 0 {
+			continue
+		}
+
+		prev := s.Points[i-1]
+		d := Distance(prev, p)
+		stats.Distance += d
+
+		if prev.HasEle && p.HasEle {
+			if p.Ele > prev.Ele {
+				stats.ElevationGain += p.Ele - prev.Ele
+			} else {
+				stats.ElevationLoss += prev.Ele - p.Ele
+			}
+		}
+
+		if prev.Time.IsZero() || p.Time.IsZero() {
+			continue
+		}
+		dt := p.Time.Sub(prev.Time)
+		if dt <= 0 {
+			continue
+		}
+		stats.HasTime = true
+		speed := d / dt.Seconds()
+		if speed < movingSpeedThreshold {
+			stats.StoppedTime += dt
+			continue
+		}
+		stats.MovingTime += dt
+		stats.MaxSpeed = math.Max(stats.MaxSpeed, speed)
+	}
+
+	if stats.MovingTime > 0 {
+		stats.AvgSpeed = stats.Distance / stats.MovingTime.Seconds()
+	}
+	return stats
+}
@@ -0,0 +1,9 @@
+OziExplorer Track Point File Version 2.1
+WGS 84
+Altitude is in Feet
+Reserved 3
+0,2,255,  1                                                 ,0,0,2,8421376
+3
+  55.750000,  37.610000,1,  492.1,43968.3958333,17-May-20, 9:30:00 AM
+  55.751000,  37.612000,0,  524.9,43968.3965278,17-May-20, 9:31:00 AM
+  55.752000,  37.614000,1,  557.7,43968.3972222,17-May-20, 9:32:00 AM
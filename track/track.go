@@ -0,0 +1,165 @@
+package track
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+type Point struct {
+	Lat    float64
+	Lon    float64
+	Ele    float64
+	HasEle bool
+	Time   time.Time
+}
+
+type Waypoint struct {
+	Point
+	Name string
+	Desc string
+}
+
+type Segment struct {
+	Name   string
+	Points []Point
+}
+
+// FormatReaderWriter is implemented by every supported track format.
+// Segments and waypoints read by one format can be handed to any other one.
+type FormatReaderWriter interface {
+	Read(r io.Reader) error
+	Write(w io.Writer) error
+	GetSegments() []Segment
+	SetSegments(segments []Segment)
+	GetWaypoints() []Waypoint
+	SetWaypoints(waypoints []Waypoint)
+}
+
+// Data holds parsed track contents and implements the accessors of FormatReaderWriter.
+type Data struct {
+	Segments  []Segment
+	Waypoints []Waypoint
+}
+
+func (d *Data) GetSegments() []Segment {
+	return d.Segments
+}
+
+func (d *Data) SetSegments(segments []Segment) {
+	d.Segments = segments
+}
+
+func (d *Data) GetWaypoints() []Waypoint {
+	return d.Waypoints
+}
+
+func (d *Data) SetWaypoints(waypoints []Waypoint) {
+	d.Waypoints = waypoints
+}
+
+func (d *Data) appendSegment(s Segment) {
+	if len(s.Points) > 0 {
+		d.Segments = append(d.Segments, s)
+	}
+}
+
+func GetFormats() map[string]func() FormatReaderWriter {
+	return map[string]func() FormatReaderWriter{
+		".gpx":     func() FormatReaderWriter { return &GpxFormat{} },
+		".kml":     func() FormatReaderWriter { return &KmlFormat{} },
+		".kmz":     func() FormatReaderWriter { return &KmzFormat{} },
+		".plt":     func() FormatReaderWriter { return &PltFormat{} },
+		".wpt":     func() FormatReaderWriter { return &WptFormat{} },
+		".rte":     func() FormatReaderWriter { return &RteFormat{} },
+		".geojson": func() FormatReaderWriter { return &GeoJsonFormat{} },
+	}
+}
+
+func IsKnownExtension(ext string) bool {
+	_, ok := GetFormats()[strings.ToLower(ext)]
+	return ok
+}
+
+func NewFormat(ext string) (FormatReaderWriter, error) {
+	factory, ok := GetFormats()[strings.ToLower(ext)]
+	if !ok {
+		return nil, &UnsupportedFormatError{Ext: ext}
+	}
+	return factory(), nil
+}
+
+func ReadFile(path string) (FormatReaderWriter, error) {
+	format, err := NewFormat(filepath.Ext(path))
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = format.Read(bytes.NewReader(content)); err != nil {
+		return nil, asParseError(err, content)
+	}
+	return format, nil
+}
+
+func WriteFile(path string, format FormatReaderWriter) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	if err = format.Write(f); err != nil {
+		f.Close()
+		os.Remove(path)
+		return err
+	}
+	return f.Close()
+}
+
+// Convert reads srcPath and writes its contents to dstPath, picking formats by
+// file extensions. Segments are thinned according to options on the way.
+func Convert(srcPath, dstPath string, options SimplifyOptions) error {
+	src, err := ReadFile(srcPath)
+	if err != nil {
+		return err
+	}
+
+	dst, err := NewFormat(filepath.Ext(dstPath))
+	if err != nil {
+		return err
+	}
+	if len(src.GetSegments()) == 0 && len(src.GetWaypoints()) == 0 {
+		return ErrEmptyTrack
+	}
+	dst.SetSegments(options.Apply(src.GetSegments()))
+	dst.SetWaypoints(src.GetWaypoints())
+
+	return WriteFile(dstPath, dst)
+}
+
+func parseTime(s string) time.Time {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return time.Time{}
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
@@ -0,0 +1,168 @@
+package track
+
+import (
+	"bytes"
+	"math"
+	"os"
+	"testing"
+	"time"
+)
+
+func testSegments() []Segment {
+	start := time.Date(2020, 5, 17, 9, 30, 0, 0, time.UTC)
+	var points []Point
+	for i := 0; i < 5; i++ {
+		points = append(points, Point{
+			Lat:    55.75 + float64(i)*0.001,
+			Lon:    37.61 + float64(i)*0.002,
+			Ele:    150 + float64(i)*10,
+			HasEle: true,
+			Time:   start.Add(time.Duration(i) * time.Minute),
+		})
+	}
+	return []Segment{
+		{Name: "Day one", Points: points[:3]},
+		{Name: "Day two", Points: points[3:]},
+	}
+}
+
+func testWaypoints() []Waypoint {
+	return []Waypoint{
+		{Point: Point{Lat: 55.7, Lon: 37.6, Ele: 120, HasEle: true}, Name: "Camp, river", Desc: "Ford"},
+		{Point: Point{Lat: 55.8, Lon: 37.7}, Name: "Bridge"},
+	}
+}
+
+func roundTrip(t *testing.T, ext string, segments []Segment, waypoints []Waypoint) FormatReaderWriter {
+	src, err := NewFormat(ext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	src.SetSegments(segments)
+	src.SetWaypoints(waypoints)
+	b := &bytes.Buffer{}
+	if err = src.Write(b); err != nil {
+		t.Fatalf("%s write: %s", ext, err)
+	}
+
+	dst, err := NewFormat(ext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = dst.Read(b); err != nil {
+		t.Fatalf("%s read: %s", ext, err)
+	}
+	return dst
+}
+
+func checkPoint(t *testing.T, ext string, want, got Point, withTime bool) {
+	if math.Abs(want.Lat-got.Lat) > 1e-6 || math.Abs(want.Lon-got.Lon) > 1e-6 {
+		t.Errorf("%s: point %v read as %v", ext, want, got)
+	}
+	if want.HasEle != got.HasEle || math.Abs(want.Ele-got.Ele) > 0.1 {
+		t.Errorf("%s: elevation %v/%v read as %v/%v", ext, want.Ele, want.HasEle, got.Ele, got.HasEle)
+	}
+	if withTime {
+		if d := want.Time.Sub(got.Time); d > time.Second || d < -time.Second {
+			t.Errorf("%s: time %s read as %s", ext, want.Time, got.Time)
+		}
+	}
+}
+
+func checkSegments(t *testing.T, ext string, want, got []Segment, withTime bool) {
+	if len(want) != len(got) {
+		t.Fatalf("%s: %d segments read as %d", ext, len(want), len(got))
+	}
+	for i := range want {
+		if len(want[i].Points) != len(got[i].Points) {
+			t.Fatalf("%s: segment %d has %d points, read %d", ext, i, len(want[i].Points), len(got[i].Points))
+		}
+		for j := range want[i].Points {
+			checkPoint(t, ext, want[i].Points[j], got[i].Points[j], withTime)
+		}
+	}
+}
+
+func checkWaypoints(t *testing.T, ext string, want, got []Waypoint) {
+	if len(want) != len(got) {
+		t.Fatalf("%s: %d waypoints read as %d", ext, len(want), len(got))
+	}
+	for i := range want {
+		if want[i].Name != got[i].Name {
+			t.Errorf("%s: waypoint name %q read as %q", ext, want[i].Name, got[i].Name)
+		}
+		checkPoint(t, ext, want[i].Point, got[i].Point, false)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, ext := range []string{".gpx", ".kml", ".kmz", ".geojson"} {
+		data := roundTrip(t, ext, testSegments(), testWaypoints())
+		checkSegments(t, ext, testSegments(), data.GetSegments(), true)
+		checkWaypoints(t, ext, testWaypoints(), data.GetWaypoints())
+	}
+}
+
+func TestPltRoundTrip(t *testing.T) {
+	data := roundTrip(t, ".plt", testSegments(), nil)
+	checkSegments(t, ".plt", testSegments(), data.GetSegments(), true)
+}
+
+func TestWptRoundTrip(t *testing.T) {
+	data := roundTrip(t, ".wpt", nil, testWaypoints())
+	checkWaypoints(t, ".wpt", testWaypoints(), data.GetWaypoints())
+}
+
+// Route points keep no altitude
+func TestRteRoundTrip(t *testing.T) {
+	want := testSegments()
+	for i := range want {
+		for j := range want[i].Points {
+			want[i].Points[j].Ele = 0
+			want[i].Points[j].HasEle = false
+		}
+	}
+	data := roundTrip(t, ".rte", testSegments(), nil)
+	checkSegments(t, ".rte", want, data.GetSegments(), true)
+}
+
+// Fixtures are in Windows-1251 with commas escaped by byte 209, as Ozi writes them
+func TestOziFixtures(t *testing.T) {
+	wpt := &WptFormat{}
+	readFixture(t, "testdata/ozi.wpt", wpt)
+	want := []Waypoint{
+		{Point: Point{Lat: 55.7, Lon: 37.6, Ele: 120.1, HasEle: true}, Name: "Лагерь, брод", Desc: "Переправа, глубоко"},
+		{Point: Point{Lat: 55.8, Lon: 37.7}, Name: "Мост"},
+	}
+	checkWaypoints(t, ".wpt", want, wpt.GetWaypoints())
+	if got := wpt.GetWaypoints()[0].Desc; got != want[0].Desc {
+		t.Errorf(".wpt: description %q read as %q", want[0].Desc, got)
+	}
+
+	plt := &PltFormat{}
+	readFixture(t, "testdata/ozi.plt", plt)
+	segments := plt.GetSegments()
+	if len(segments) != 2 || len(segments[0].Points) != 2 || len(segments[1].Points) != 1 {
+		t.Fatalf(".plt: segments read as %v", segments)
+	}
+	if segments[0].Name != "Выезд, день 1" {
+		t.Errorf(".plt: track name read as %q", segments[0].Name)
+	}
+	start := time.Date(2020, 5, 17, 9, 30, 0, 0, time.UTC)
+	checkPoint(t, ".plt", Point{Lat: 55.75, Lon: 37.61, Ele: 150, HasEle: true, Time: start}, segments[0].Points[0], true)
+
+	if got, want := oziEscape("Лагерь, брод"), "\xcb\xe0\xe3\xe5\xf0\xfc\xd1 \xe1\xf0\xee\xe4"; got != want {
+		t.Errorf("Escaped as %q, want %q", got, want)
+	}
+}
+
+func readFixture(t *testing.T, path string, format FormatReaderWriter) {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err = format.Read(f); err != nil {
+		t.Fatalf("%s: %s", path, err)
+	}
+}
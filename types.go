@@ -5,6 +5,12 @@ type Config struct {
 	Token      string
 	WorkDir    string
 	RuntimeDir string
+	// Telegram user IDs allowed to run admin commands
+	Admins []int
+	// CIDR ranges or addresses the diagnostics command may probe even though
+	// they are private, and ranges denied in addition to private ones
+	DiagAllow []string
+	DiagDeny  []string
 }
 
 type ConversionInfo struct {